@@ -40,7 +40,7 @@ export async function action({ request, context }: Route.ActionArgs) {
     return { success: false, error: agents.reason };
   }
 
-  await agents.value.triggerSync();
+  await agents.value.triggerSync(request.headers.get("traceparent"));
   const sync = agents.value.lastSync();
   return { success: !sync.error, error: sync.error };
 }
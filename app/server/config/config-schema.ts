@@ -196,6 +196,39 @@ const partialOidcConfig = type({
   strict_validation: type("unknown").narrow(deprecatedField()).optional(),
 });
 
+// Optional agent features. Each one is passed to the agent process as the
+// environment variable listed in `agentSettingsEnv` (see hp-agent.ts).
+const agentSettings = {
+  posture_file: "string?",
+  stale_after: "string?",
+  metrics_listen: "string?",
+  otlp_endpoint: "string?",
+  history_retention: "string?",
+  webhooks_file: "string?",
+  key_expiry_warning: "string?",
+  alerts_file: "string?",
+  probes_file: "string?",
+  certs_file: "string?",
+  subnets_file: "string?",
+  accept_routes: "boolean?",
+  egress_check_url: "string.url?",
+  sd_file: "string?",
+  zone_dir: "string?",
+  zone_interval: "string?",
+  zone_ns: "string?",
+  zone_txt: "boolean?",
+  proxy_listen: "string?",
+  proxy_password: "string?",
+  "proxy_allow?": "string | string[]",
+  serve_upstream: "string.url?",
+  serve_listen: "string?",
+  serve_tls_listen: "string?",
+  serve_tls_cert: "string?",
+  serve_tls_key: "string?",
+  oidc_file: "string?",
+  web_proxy_listen: "string?",
+} as const;
+
 const agentConfig = type({
   enabled: "boolean",
   host_name: 'string = "headplane-agent"',
@@ -204,6 +237,7 @@ const agentConfig = type({
   work_dir: 'string = "/var/lib/headplane/agent"',
   pre_authkey: type("unknown").narrow(deprecatedField()).optional(),
   cache_path: type("unknown").narrow(deprecatedField()).optional(),
+  ...agentSettings,
 });
 
 const partialAgentConfig = type({
@@ -214,6 +248,7 @@ const partialAgentConfig = type({
   work_dir: "string?",
   pre_authkey: type("unknown").narrow(deprecatedField()).optional(),
   cache_path: type("unknown").narrow(deprecatedField()).optional(),
+  ...agentSettings,
 });
 
 const integrationConfig = type({
@@ -8,6 +8,7 @@ import { NodeSQLiteDatabase } from "drizzle-orm/node-sqlite";
 
 import { HostInfo } from "~/types";
 import log from "~/utils/log";
+import { agentTraceparent } from "~/utils/trace-context";
 
 import { HeadplaneConfig } from "./config/config-schema";
 import { hostInfo } from "./db/schema";
@@ -17,10 +18,49 @@ export interface AgentManager {
   lookup(nodeKeys: string[]): Promise<Record<string, HostInfo>>;
   lastSync(): { syncedAt: Date | null; nodeCount: number; error?: string };
   agentNodeKey(): string | undefined;
-  triggerSync(): Promise<void>;
+  triggerSync(traceparent?: string | null): Promise<void>;
   dispose(): void;
 }
 
+type AgentConfig = NonNullable<NonNullable<HeadplaneConfig["integration"]>["agent"]>;
+type AgentSetting = keyof typeof agentSettingsEnv;
+
+/**
+ * Optional agent settings and the environment variables they are passed to
+ * the agent process as. The agent reads nothing else from Headplane's
+ * environment.
+ */
+const agentSettingsEnv = {
+  posture_file: "HEADPLANE_AGENT_POSTURE_FILE",
+  stale_after: "HEADPLANE_AGENT_STALE_AFTER",
+  metrics_listen: "HEADPLANE_AGENT_METRICS_LISTEN",
+  otlp_endpoint: "HEADPLANE_AGENT_OTLP_ENDPOINT",
+  history_retention: "HEADPLANE_AGENT_HISTORY_RETENTION",
+  webhooks_file: "HEADPLANE_AGENT_WEBHOOKS_FILE",
+  key_expiry_warning: "HEADPLANE_AGENT_KEY_EXPIRY_WARNING",
+  alerts_file: "HEADPLANE_AGENT_ALERTS_FILE",
+  probes_file: "HEADPLANE_AGENT_PROBES_FILE",
+  certs_file: "HEADPLANE_AGENT_CERTS_FILE",
+  subnets_file: "HEADPLANE_AGENT_SUBNETS_FILE",
+  accept_routes: "HEADPLANE_AGENT_ACCEPT_ROUTES",
+  egress_check_url: "HEADPLANE_AGENT_EGRESS_CHECK_URL",
+  sd_file: "HEADPLANE_AGENT_SD_FILE",
+  zone_dir: "HEADPLANE_AGENT_ZONE_DIR",
+  zone_interval: "HEADPLANE_AGENT_ZONE_INTERVAL",
+  zone_ns: "HEADPLANE_AGENT_ZONE_NS",
+  zone_txt: "HEADPLANE_AGENT_ZONE_TXT",
+  proxy_listen: "HEADPLANE_AGENT_PROXY_LISTEN",
+  proxy_password: "HEADPLANE_AGENT_PROXY_PASSWORD",
+  proxy_allow: "HEADPLANE_AGENT_PROXY_ALLOW",
+  serve_upstream: "HEADPLANE_AGENT_SERVE_UPSTREAM",
+  serve_listen: "HEADPLANE_AGENT_SERVE_LISTEN",
+  serve_tls_listen: "HEADPLANE_AGENT_SERVE_TLS_LISTEN",
+  serve_tls_cert: "HEADPLANE_AGENT_SERVE_TLS_CERT",
+  serve_tls_key: "HEADPLANE_AGENT_SERVE_TLS_KEY",
+  oidc_file: "HEADPLANE_AGENT_OIDC_FILE",
+  web_proxy_listen: "HEADPLANE_AGENT_WEB_PROXY_LISTEN",
+} as const satisfies Partial<Record<keyof AgentConfig, string>>;
+
 interface AgentOutput {
   self: string;
   hosts: Record<string, HostInfo>;
@@ -44,7 +84,7 @@ async function hasExistingState(workDir: string): Promise<boolean> {
 }
 
 export async function createAgentManager(
-  agentConfig: NonNullable<NonNullable<HeadplaneConfig["integration"]>["agent"]> | undefined,
+  agentConfig: AgentConfig | undefined,
   headscaleUrl: string,
   apiClient: HeadscaleClient,
   supportsTagOnlyKeys: boolean,
@@ -120,6 +160,15 @@ export async function createAgentManager(
       HEADPLANE_AGENT_DEBUG: log.debugEnabled ? "true" : "false",
     };
 
+    for (const [key, name] of Object.entries(agentSettingsEnv)) {
+      const value = agentConfig?.[key as AgentSetting];
+      if (value === undefined) {
+        continue;
+      }
+
+      env[name] = Array.isArray(value) ? value.join(",") : String(value);
+    }
+
     if (authKey) {
       env.HEADPLANE_AGENT_TS_AUTHKEY = authKey;
     }
@@ -178,15 +227,19 @@ export async function createAgentManager(
     return spawnAgent(await generateAuthKey());
   }
 
-  function sendSync(child: ChildProcess): Promise<string> {
+  /**
+   * Sends a sync request carrying W3C trace context, if any, which the agent
+   * uses as the parent of the spans it records for the sync.
+   */
+  function sendSync(child: ChildProcess, traceparent?: string): Promise<string> {
     return new Promise((resolve) => {
       responseHandler = resolve;
-      child.stdin?.write("sync\n");
+      child.stdin?.write(`sync ${JSON.stringify({ traceparent })}\n`);
     });
   }
 
-  async function requestSync(child: ChildProcess): Promise<AgentOutput> {
-    const line = await sendSync(child);
+  async function requestSync(child: ChildProcess, traceparent?: string): Promise<AgentOutput> {
+    const line = await sendSync(child, traceparent);
     if (!line) {
       throw new Error("Agent process closed unexpectedly");
     }
@@ -196,7 +249,7 @@ export async function createAgentManager(
   let isSyncing = false;
   let pendingResync = false;
 
-  async function sync() {
+  async function sync(parentTraceparent?: string | null) {
     if (isSyncing) {
       pendingResync = true;
       log.debug("agent", "Sync already in progress, queued resync");
@@ -206,7 +259,9 @@ export async function createAgentManager(
     isSyncing = true;
     try {
       const child = await ensureProcess();
-      const output = await requestSync(child);
+      const traceparent = agentTraceparent(parentTraceparent);
+      log.debug("agent", "Requesting sync (traceparent %s)", traceparent ?? "none");
+      const output = await requestSync(child, traceparent);
 
       if (output.error) {
         consecutiveErrors++;
@@ -349,8 +404,8 @@ export async function createAgentManager(
       return state.selfKey;
     },
 
-    async triggerSync() {
-      await sync();
+    async triggerSync(traceparent) {
+      await sync(traceparent);
     },
 
     dispose() {
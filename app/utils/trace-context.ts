@@ -0,0 +1,17 @@
+const traceparentPattern = /^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$/;
+
+/**
+ * Returns the W3C `traceparent` to send with a request to the agent. A valid
+ * `parent`, such as one received by Headplane, is passed through unchanged so
+ * the agent's spans hang off the caller's span. Headplane records no spans of
+ * its own, so without a valid parent nothing is returned and the agent starts
+ * a new trace itself.
+ */
+export function agentTraceparent(parent?: string | null): string | undefined {
+  const match = parent ? traceparentPattern.exec(parent) : null;
+  if (!match || /^0+$/.test(match[1]) || /^0+$/.test(match[2])) {
+    return undefined;
+  }
+
+  return parent ?? undefined;
+}
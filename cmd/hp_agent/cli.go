@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tale/headplane/internal/config"
+	"github.com/tale/headplane/internal/inventory"
+	"tailscale.com/atomicfile"
+)
+
+// cliCommands are the export subcommands. Without one of these, arguments
+// are treated as an Ansible inventory invocation.
+var cliCommands = map[string]func(args []string) error{
+	"ssh-config": sshConfig,
+	"export":     exportInventory,
+}
+
+// runCLI handles hp_agent being invoked with arguments. Every mode reads the
+// inventory snapshot kept by the running agent.
+func runCLI(args []string) error {
+	if cmd, ok := cliCommands[args[0]]; ok {
+		return cmd(args[1:])
+	}
+
+	return ansibleInventory(args)
+}
+
+// workDirFlag registers the --work-dir flag shared by every subcommand.
+func workDirFlag(fs *flag.FlagSet) *string {
+	return fs.String("work-dir", os.Getenv(config.WorkDirEnv), "agent work directory")
+}
+
+// writeOutput writes data to path atomically, or to stdout if path is empty.
+func writeOutput(path string, data []byte) error {
+	if path == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	return atomicfile.WriteFile(path, data, 0644)
+}
+
+// ansibleInventory implements the Ansible dynamic inventory script
+// interface, so hp_agent can be passed to ansible with -i.
+func ansibleInventory(args []string) error {
+	fs := flag.NewFlagSet("hp_agent", flag.ContinueOnError)
+	list := fs.Bool("list", false, "list every host and group")
+	host := fs.String("host", "", "show the variables of a single host")
+	workDir := workDirFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !*list && *host == "" {
+		fs.Usage()
+		return errors.New("one of --list or --host is required")
+	}
+
+	snap, err := inventory.Load(*workDir)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	if *list {
+		return enc.Encode(inventory.Ansible(snap.Hosts))
+	}
+
+	for _, h := range snap.Hosts {
+		if h.Name == *host || h.ShortName() == *host {
+			return enc.Encode(inventory.AnsibleHostVars(h))
+		}
+	}
+
+	return enc.Encode(map[string]any{})
+}
+
+// sshConfig writes an ssh_config fragment with a Host block per node and,
+// optionally, a known_hosts file with the nodes' SSH host keys.
+func sshConfig(args []string) error {
+	fs := flag.NewFlagSet("hp_agent ssh-config", flag.ContinueOnError)
+	workDir := workDirFlag(fs)
+	settings := fs.String("config", "", "JSON file with per-tag users, ports and jump hosts")
+	out := fs.String("out", "", "write the ssh_config fragment here instead of stdout")
+	knownHosts := fs.String("known-hosts", "", "write the nodes' SSH host keys to this known_hosts file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := new(inventory.SSHConfig)
+	if *settings != "" {
+		var err error
+		if cfg, err = inventory.LoadSSHConfig(*settings); err != nil {
+			return err
+		}
+	}
+
+	snap, err := inventory.Load(*workDir)
+	if err != nil {
+		return err
+	}
+
+	if *knownHosts != "" {
+		var b bytes.Buffer
+		if err := inventory.WriteKnownHosts(&b, snap.Hosts); err != nil {
+			return err
+		}
+
+		if err := writeOutput(*knownHosts, b.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	var b bytes.Buffer
+	if err := cfg.WriteSSHConfig(&b, snap.Hosts, *knownHosts); err != nil {
+		return err
+	}
+
+	return writeOutput(*out, b.Bytes())
+}
+
+// exportInventory writes the flattened host list in the snapshot as CSV,
+// JSON or YAML.
+func exportInventory(args []string) error {
+	fs := flag.NewFlagSet("hp_agent export", flag.ContinueOnError)
+	workDir := workDirFlag(fs)
+	format := fs.String("format", "csv", "output format: csv, json or yaml")
+	columns := fs.String("columns", strings.Join(inventory.Columns, ","), "comma-separated columns to include")
+	tag := fs.String("tag", "", "only include nodes with this tag")
+	user := fs.String("user", "", "only include nodes owned by this user")
+	online := fs.String("online", "", "only include online (true) or offline (false) nodes")
+	out := fs.String("out", "", "write the export here instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := inventory.Filter{Tag: *tag, User: *user}
+	if *online != "" {
+		v, err := strconv.ParseBool(*online)
+		if err != nil {
+			return fmt.Errorf("invalid --online: %w", err)
+		}
+
+		filter.Online = &v
+	}
+
+	snap, err := inventory.Load(*workDir)
+	if err != nil {
+		return err
+	}
+
+	snap.Hosts = slices.DeleteFunc(snap.Hosts, func(h inventory.Host) bool { return !filter.Match(h) })
+
+	var b bytes.Buffer
+	if err := inventory.Export(&b, *format, snap, strings.Split(*columns, ",")); err != nil {
+		return err
+	}
+
+	return writeOutput(*out, b.Bytes())
+}
@@ -0,0 +1,681 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tale/headplane/internal/alerts"
+	"github.com/tale/headplane/internal/certs"
+	"github.com/tale/headplane/internal/config"
+	"github.com/tale/headplane/internal/events"
+	"github.com/tale/headplane/internal/history"
+	"github.com/tale/headplane/internal/hygiene"
+	"github.com/tale/headplane/internal/metrics"
+	"github.com/tale/headplane/internal/posture"
+	"github.com/tale/headplane/internal/probe"
+	"github.com/tale/headplane/internal/subnets"
+	"github.com/tale/headplane/internal/tracing"
+	"github.com/tale/headplane/internal/tsnet"
+	"github.com/tale/headplane/internal/util"
+	"github.com/tale/headplane/internal/webproxy"
+)
+
+// server holds the state shared by every command handler.
+type server struct {
+	cfg     *config.Config
+	agent   *tsnet.TSAgent
+	posture *posture.Policy
+	nodes   *hygiene.Cache
+	history *history.Store
+	events  *events.Log
+	alerts  *alerts.Engine
+	probes  *probe.Runner
+	certs   *certs.Monitor
+	subnets *subnets.Checker
+	web     *webproxy.Handler
+
+	recording atomic.Bool
+
+	// egressRunning is set while an exit node check runs in the background
+	// and egressLast holds the result of the last one to finish.
+	egressRunning atomic.Bool
+	egressMu      sync.Mutex
+	egressLast    egressResult
+
+	// failing tracks nodes that failed posture on the last sync so that a
+	// failure is only reported when it first happens.
+	failing map[string]bool
+}
+
+// command handles a single request from Headplane. The returned value is
+// encoded as one JSON line on stdout.
+type command func(s *server, ctx context.Context, args json.RawMessage) (any, error)
+
+var commands = map[string]command{
+	"sync":     (*server).sync,
+	"summary":  (*server).summary,
+	"hygiene":  (*server).hygiene,
+	"dns":      (*server).dns,
+	"history":  (*server).nodeHistory,
+	"events":   (*server).tailnetEvents,
+	"alerts":   (*server).activeAlerts,
+	"probes":   (*server).probeResults,
+	"certs":    (*server).certReports,
+	"subnets":  (*server).subnetReports,
+	"egress":   (*server).egress,
+	"topology": (*server).topology,
+	"whois":    (*server).whois,
+	"webproxy": (*server).webProxyGrant,
+}
+
+type errorOutput struct {
+	Error string `json:"error"`
+}
+
+// traceArgs is the W3C trace context Headplane may send with any command so
+// that the agent's spans join the trace of the request that triggered them.
+type traceArgs struct {
+	Traceparent string `json:"traceparent"`
+	Tracestate  string `json:"tracestate"`
+}
+
+// handle runs a single request line and returns the response to encode.
+func (s *server) handle(ctx context.Context, line string) any {
+	name, args := parseRequest(line)
+
+	// Commands validate their own arguments, so a bad payload here only
+	// means the request is not part of a trace.
+	var tc traceArgs
+	decodeArgs(args, &tc)
+	ctx = tracing.Extract(ctx, tc.Traceparent, tc.Tracestate)
+
+	ctx, span := tracing.Start(ctx, "agent."+name)
+	cmd, ok := commands[name]
+	if !ok {
+		err := fmt.Errorf("unknown command: %s", name)
+		tracing.End(span, err)
+		return errorOutput{Error: err.Error()}
+	}
+
+	res, err := cmd(s, ctx, args)
+	tracing.End(span, err)
+	if err != nil {
+		return errorOutput{Error: err.Error()}
+	}
+
+	return res
+}
+
+// parseRequest splits a request line into the command name and its optional
+// JSON arguments. An empty line is treated as a sync for compatibility with
+// older versions of Headplane that ignored the line content.
+func parseRequest(line string) (string, json.RawMessage) {
+	name, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if name == "" {
+		name = "sync"
+	}
+
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return name, nil
+	}
+
+	return name, json.RawMessage(args)
+}
+
+// decodeArgs unmarshals command arguments into v, leaving v untouched when
+// no arguments were sent.
+func decodeArgs(args json.RawMessage, v any) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(args, v)
+}
+
+type output struct {
+	Self    string                     `json:"self"`
+	Hosts   map[string]json.RawMessage `json:"hosts"`
+	Posture map[string]*posture.Result `json:"posture,omitempty"`
+	Alerts  []alerts.Frame             `json:"alerts,omitempty"`
+	Probes  map[string][]probe.Result  `json:"probes,omitempty"`
+}
+
+// fetchNodes queries the tailnet and records the results in the node cache.
+func (s *server) fetchNodes(ctx context.Context) (map[string]*tsnet.Node, error) {
+	start := time.Now()
+	nodes, err := s.agent.FetchNodes(ctx)
+	metrics.SyncDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.Syncs.Add("error", 1)
+		return nil, err
+	}
+
+	metrics.Syncs.Add("ok", 1)
+	metrics.Peers.Init()
+	metrics.Peers.SetInt64("online", 0)
+	metrics.Peers.SetInt64("offline", 0)
+	for _, n := range nodes {
+		if n.Status.Online {
+			metrics.Peers.Add("online", 1)
+		} else {
+			metrics.Peers.Add("offline", 1)
+		}
+	}
+
+	if err := s.nodes.Update(nodes, time.Now()); err != nil {
+		util.GetLogger().Error("Failed to update node cache: %s", err)
+	}
+
+	return nodes, nil
+}
+
+func (s *server) sync(ctx context.Context, _ json.RawMessage) (any, error) {
+	nodes, err := s.fetchNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordHistory(nodes)
+	out := output{
+		Self:  s.agent.ID,
+		Hosts: tsnet.HostInfoPayloads(nodes),
+	}
+
+	if s.posture != nil {
+		out.Posture = s.posture.Evaluate(nodes)
+
+		metrics.PostureNodes.Init()
+		metrics.PostureNodes.SetInt64("pass", 0)
+		metrics.PostureNodes.SetInt64("fail", 0)
+		for _, res := range out.Posture {
+			if res.Pass {
+				metrics.PostureNodes.Add("pass", 1)
+			} else {
+				metrics.PostureNodes.Add("fail", 1)
+			}
+		}
+
+		s.recordPostureFailures(nodes, out.Posture)
+	}
+
+	if s.alerts != nil {
+		s.alerts.Update(alerts.NodesFrom(nodes), time.Now())
+		out.Alerts = s.alerts.Drain()
+	}
+
+	if s.probes != nil {
+		out.Probes = s.probes.Results()
+	}
+
+	return out, nil
+}
+
+// recordPostureFailures emits an event for every node that newly fails
+// the posture policy.
+func (s *server) recordPostureFailures(nodes map[string]*tsnet.Node, results map[string]*posture.Result) {
+	now := time.Now()
+	failing := make(map[string]bool)
+
+	var evs []events.Event
+	for id, res := range results {
+		if res.Pass {
+			continue
+		}
+
+		failing[id] = true
+		if s.failing[id] {
+			continue
+		}
+
+		n := nodes[id]
+		evs = append(evs, events.Event{
+			Time: now,
+			Type: events.NodePostureFailed,
+			Node: string(n.Status.ID),
+			Name: strings.TrimSuffix(n.Status.DNSName, "."),
+			New:  res.Failures,
+		})
+	}
+
+	s.failing = failing
+	if err := s.events.Append(evs...); err != nil {
+		util.GetLogger().Error("Failed to record posture failures: %s", err)
+	}
+}
+
+type summaryOutput struct {
+	Self    string              `json:"self"`
+	Summary *tsnet.FleetSummary `json:"summary"`
+}
+
+func (s *server) summary(ctx context.Context, _ json.RawMessage) (any, error) {
+	nodes, err := s.fetchNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return summaryOutput{
+		Self:    s.agent.ID,
+		Summary: tsnet.Summarize(nodes),
+	}, nil
+}
+
+type hygieneArgs struct {
+	StaleAfter string `json:"staleAfter"`
+}
+
+type hygieneOutput struct {
+	Self    string          `json:"self"`
+	Hygiene *hygiene.Report `json:"hygiene"`
+}
+
+func (s *server) hygiene(ctx context.Context, args json.RawMessage) (any, error) {
+	var a hygieneArgs
+	if err := decodeArgs(args, &a); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	staleAfter := s.cfg.StaleAfter
+	if a.StaleAfter != "" {
+		d, err := time.ParseDuration(a.StaleAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid staleAfter: %w", err)
+		}
+
+		staleAfter = d
+	}
+
+	if _, err := s.fetchNodes(ctx); err != nil {
+		return nil, err
+	}
+
+	report := hygiene.Analyze(s.nodes.Snapshot(), staleAfter, time.Now())
+	metrics.Duplicates.Set(int64(len(report.Duplicates)))
+	metrics.StaleNodes.Set(int64(len(report.Stale)))
+
+	return hygieneOutput{
+		Self:    s.agent.ID,
+		Hygiene: report,
+	}, nil
+}
+
+type dnsOutput struct {
+	Self string           `json:"self"`
+	DNS  *tsnet.DNSReport `json:"dns"`
+}
+
+func (s *server) dns(ctx context.Context, _ json.RawMessage) (any, error) {
+	report, err := s.agent.VerifyDNS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.DNSNames.Init()
+	for _, c := range report.Names {
+		metrics.DNSNames.Add(c.Status, 1)
+	}
+
+	metrics.DNSUpstreams.Init()
+	for _, c := range report.SplitDNS {
+		metrics.DNSUpstreams.Add(c.Status, 1)
+	}
+
+	return dnsOutput{Self: s.agent.ID, DNS: report}, nil
+}
+
+// recordHistory pings every node in the background and appends the result
+// to the history store. A sync that arrives while the previous recording is
+// still running is not recorded.
+func (s *server) recordHistory(nodes map[string]*tsnet.Node) {
+	if s.history == nil || !s.recording.CompareAndSwap(false, true) {
+		return
+	}
+
+	now := time.Now()
+	go func() {
+		defer s.recording.Store(false)
+
+		paths := s.agent.ProbePaths(context.Background(), nodes)
+		points := make(map[string]history.Point, len(paths))
+		for id, p := range paths {
+			// Samples are keyed by stable ID so that a key rotation does
+			// not split a node's history in two.
+			points[string(nodes[id].Node.StableID)] = history.Point{
+				Online:    p.Online,
+				Path:      p.Path,
+				DERP:      p.DERP,
+				LatencyMs: float64(p.Latency) / float64(time.Millisecond),
+			}
+		}
+
+		if err := s.history.Append(now, points); err != nil {
+			util.GetLogger().Error("Failed to record node history: %s", err)
+		}
+	}()
+}
+
+type historyArgs struct {
+	Node   string `json:"node"`
+	Window string `json:"window"`
+}
+
+type historyOutput struct {
+	Self    string               `json:"self"`
+	History *history.NodeHistory `json:"history"`
+}
+
+func (s *server) nodeHistory(_ context.Context, args json.RawMessage) (any, error) {
+	if s.history == nil {
+		return nil, fmt.Errorf("node history is disabled")
+	}
+
+	a := historyArgs{Window: "24h"}
+	if err := decodeArgs(args, &a); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	if a.Node == "" {
+		return nil, fmt.Errorf("node is required")
+	}
+
+	window, err := time.ParseDuration(a.Window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window: %w", err)
+	}
+
+	// Headplane refers to nodes by node key; history is kept by stable ID.
+	node := a.Node
+	if strings.HasPrefix(node, "nodekey:") {
+		node = s.nodes.StableID(node)
+		if node == "" {
+			return nil, fmt.Errorf("unknown node: %s", a.Node)
+		}
+	}
+
+	now := time.Now()
+	h, err := s.history.ForNode(node, now.Add(-window), now)
+	if err != nil {
+		return nil, err
+	}
+
+	return historyOutput{Self: s.agent.ID, History: h}, nil
+}
+
+type eventsOutput struct {
+	Self   string         `json:"self"`
+	Events []events.Event `json:"events"`
+}
+
+func (s *server) tailnetEvents(_ context.Context, args json.RawMessage) (any, error) {
+	var f events.Filter
+	if err := decodeArgs(args, &f); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	evs, err := s.events.Query(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return eventsOutput{Self: s.agent.ID, Events: evs}, nil
+}
+
+type alertsOutput struct {
+	Self   string         `json:"self"`
+	Alerts []alerts.Frame `json:"alerts"`
+}
+
+func (s *server) activeAlerts(_ context.Context, _ json.RawMessage) (any, error) {
+	if s.alerts == nil {
+		return nil, fmt.Errorf("no alert rules are configured")
+	}
+
+	return alertsOutput{Self: s.agent.ID, Alerts: s.alerts.Active()}, nil
+}
+
+type probesOutput struct {
+	Self   string                    `json:"self"`
+	Probes map[string][]probe.Result `json:"probes"`
+}
+
+func (s *server) probeResults(_ context.Context, _ json.RawMessage) (any, error) {
+	if s.probes == nil {
+		return nil, fmt.Errorf("no probes are configured")
+	}
+
+	return probesOutput{Self: s.agent.ID, Probes: s.probes.Results()}, nil
+}
+
+type certsOutput struct {
+	Self  string         `json:"self"`
+	Certs []certs.Report `json:"certs"`
+}
+
+func (s *server) certReports(_ context.Context, _ json.RawMessage) (any, error) {
+	if s.certs == nil {
+		return nil, fmt.Errorf("no certificate targets are configured")
+	}
+
+	return certsOutput{Self: s.agent.ID, Certs: s.certs.Reports()}, nil
+}
+
+type subnetsOutput struct {
+	Self    string                 `json:"self"`
+	Subnets []subnets.PrefixReport `json:"subnets"`
+}
+
+func (s *server) subnetReports(_ context.Context, _ json.RawMessage) (any, error) {
+	if s.subnets == nil {
+		return nil, fmt.Errorf("no subnet targets are configured")
+	}
+
+	return subnetsOutput{Self: s.agent.ID, Subnets: s.subnets.Reports()}, nil
+}
+
+type egressArgs struct {
+	URL string `json:"url"`
+}
+
+// egressResult is the outcome of the last exit node check. CheckedAt is
+// zero until a check has finished.
+type egressResult struct {
+	Checks    []tsnet.EgressCheck `json:"checks"`
+	CheckedAt time.Time           `json:"checkedAt,omitzero"`
+	Error     string              `json:"error,omitempty"`
+}
+
+type egressOutput struct {
+	Self    string `json:"self"`
+	Running bool   `json:"running"`
+	egressResult
+}
+
+// egress starts an exit node check in the background unless one is already
+// running, and returns the result of the last check to finish. A check can
+// take minutes, so it must not hold up the other requests.
+func (s *server) egress(_ context.Context, raw json.RawMessage) (any, error) {
+	args := egressArgs{URL: s.cfg.EgressCheckURL}
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+
+	if args.URL == "" {
+		return nil, fmt.Errorf("no egress check URL is configured")
+	}
+
+	if s.egressRunning.CompareAndSwap(false, true) {
+		go func() {
+			defer s.egressRunning.Store(false)
+
+			res := egressResult{Checks: []tsnet.EgressCheck{}}
+			checks, err := s.agent.VerifyExitNodes(context.Background(), args.URL)
+			if err != nil {
+				util.GetLogger().Error("Exit node check failed: %s", err)
+				res.Error = err.Error()
+			} else {
+				res.Checks = checks
+			}
+
+			res.CheckedAt = time.Now()
+			s.egressMu.Lock()
+			s.egressLast = res
+			s.egressMu.Unlock()
+		}()
+	}
+
+	s.egressMu.Lock()
+	last := s.egressLast
+	s.egressMu.Unlock()
+
+	if last.Checks == nil {
+		last.Checks = []tsnet.EgressCheck{}
+	}
+
+	return egressOutput{Self: s.agent.ID, Running: s.egressRunning.Load(), egressResult: last}, nil
+}
+
+type topologyArgs struct {
+	Format string `json:"format"`
+}
+
+type topologyOutput struct {
+	Self  string       `json:"self"`
+	Graph *tsnet.Graph `json:"graph,omitempty"`
+	DOT   string       `json:"dot,omitempty"`
+}
+
+func (s *server) topology(ctx context.Context, raw json.RawMessage) (any, error) {
+	var args topologyArgs
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+
+	g, err := s.agent.Topology(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch args.Format {
+	case "", "json":
+		return topologyOutput{Self: s.agent.ID, Graph: g}, nil
+	case "dot":
+		return topologyOutput{Self: s.agent.ID, DOT: g.DOT()}, nil
+	}
+
+	return nil, fmt.Errorf("unknown topology format %q", args.Format)
+}
+
+type whoisArgs struct {
+	Addr string `json:"addr"`
+}
+
+type whoisOutput struct {
+	Self     string          `json:"self"`
+	Identity *tsnet.Identity `json:"identity"`
+}
+
+// whois identifies the tailnet user and node behind a remote address, so
+// Headplane can sign in users connecting over the tailnet.
+func (s *server) whois(ctx context.Context, raw json.RawMessage) (any, error) {
+	var args whoisArgs
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, err
+	}
+
+	id, err := s.agent.Identify(ctx, args.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return whoisOutput{Self: s.agent.ID, Identity: id}, nil
+}
+
+type webProxyArgs struct {
+	User  string   `json:"user"`
+	Allow []string `json:"allow"`
+	TTL   string   `json:"ttl"`
+}
+
+type webProxyOutput struct {
+	Self    string    `json:"self"`
+	Token   string    `json:"token,omitempty"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// webProxyGrant issues the token Headplane sends with a user's requests to
+// the web proxy, limited to the nodes in allow. An empty allowlist revokes
+// the user's token.
+func (s *server) webProxyGrant(ctx context.Context, raw json.RawMessage) (any, error) {
+	if s.web == nil {
+		return nil, fmt.Errorf("the web proxy is not configured")
+	}
+
+	args := webProxyArgs{TTL: "1h"}
+	if err := decodeArgs(raw, &args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	if args.User == "" {
+		return nil, fmt.Errorf("user is required")
+	}
+
+	if len(args.Allow) == 0 {
+		s.web.Revoke(args.User)
+		return webProxyOutput{Self: s.agent.ID}, nil
+	}
+
+	ttl, err := time.ParseDuration(args.TTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid ttl %q", args.TTL)
+	}
+
+	token, expires, err := s.web.Grant(args.User, args.Allow, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return webProxyOutput{Self: s.agent.ID, Token: token, Expires: expires}, nil
+}
+
+// recordCertExpiring writes a warning for a certificate that is about to
+// expire to the event log.
+func (s *server) recordCertExpiring(r certs.Report) {
+	err := s.events.Append(events.Event{
+		Time: r.CheckedAt,
+		Type: events.CertExpiring,
+		Node: r.Node,
+		Name: r.Target,
+		New:  r.NotAfter,
+	})
+
+	if err != nil {
+		util.GetLogger().Error("Failed to record certificate expiry: %s", err)
+	}
+}
+
+// recordAlert writes an alert transition to the event log so it reaches
+// webhooks and the audit history.
+func (s *server) recordAlert(f alerts.Frame) {
+	typ := events.AlertFiring
+	if f.State == alerts.Resolved {
+		typ = events.AlertResolved
+	}
+
+	err := s.events.Append(events.Event{
+		Time: f.At,
+		Type: typ,
+		Node: f.Node,
+		Name: f.Name,
+		New:  f,
+	})
+
+	if err != nil {
+		util.GetLogger().Error("Failed to record alert: %s", err)
+	}
+}
@@ -4,36 +4,296 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/tale/headplane/internal/alerts"
+	"github.com/tale/headplane/internal/certs"
 	"github.com/tale/headplane/internal/config"
+	"github.com/tale/headplane/internal/events"
+	"github.com/tale/headplane/internal/history"
+	"github.com/tale/headplane/internal/hygiene"
+	"github.com/tale/headplane/internal/inventory"
+	"github.com/tale/headplane/internal/metrics"
+	"github.com/tale/headplane/internal/oidc"
+	"github.com/tale/headplane/internal/posture"
+	"github.com/tale/headplane/internal/probe"
+	"github.com/tale/headplane/internal/promsd"
+	"github.com/tale/headplane/internal/proxy"
+	"github.com/tale/headplane/internal/serve"
+	"github.com/tale/headplane/internal/subnets"
+	"github.com/tale/headplane/internal/tracing"
 	"github.com/tale/headplane/internal/tsnet"
 	"github.com/tale/headplane/internal/util"
+	"github.com/tale/headplane/internal/webhook"
+	"github.com/tale/headplane/internal/webproxy"
+	"github.com/tale/headplane/internal/zone"
+	"tailscale.com/types/netmap"
 )
 
-type output struct {
-	Self  string                     `json:"self"`
-	Hosts map[string]json.RawMessage `json:"hosts"`
-}
-
-type errorOutput struct {
-	Error string `json:"error"`
-}
-
 func main() {
 	log := util.GetLogger()
+	if len(os.Args) > 1 {
+		if err := runCLI(os.Args[1:]); err != nil {
+			log.Fatal("%s", err)
+		}
+
+		return
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatal("Failed to load config: %s", err)
 	}
 
 	log.SetDebug(cfg.Debug)
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.OTLPEndpoint != "" {
+		shutdownTracing, err = tracing.Init(context.Background(), cfg.OTLPEndpoint)
+		if err != nil {
+			log.Fatal("Failed to set up tracing: %s", err)
+		}
+	}
+	defer shutdownTracing(context.Background())
+
+	ctx, span := tracing.Start(context.Background(), "agent.preflight")
+	err = config.Preflight(ctx, cfg)
+	tracing.End(span, err)
+	if err != nil {
+		log.Fatal("Preflight check failed: %s", err)
+	}
+
+	srv := &server{cfg: cfg}
+	if cfg.PostureFile != "" {
+		srv.posture, err = posture.Load(cfg.PostureFile)
+		if err != nil {
+			log.Fatal("Failed to load posture policy: %s", err)
+		}
+	}
+
+	srv.nodes, err = hygiene.LoadCache(cfg.WorkDir)
+	if err != nil {
+		log.Fatal("Failed to load node cache: %s", err)
+	}
+
+	if cfg.HistoryRetention > 0 {
+		srv.history, err = history.Open(cfg.WorkDir, cfg.HistoryRetention)
+		if err != nil {
+			log.Fatal("Failed to open node history: %s", err)
+		}
+	}
+
+	srv.events, err = events.Open(cfg.WorkDir)
+	if err != nil {
+		log.Fatal("Failed to open event log: %s", err)
+	}
+
+	tracker := events.NewTracker(cfg.WorkDir, srv.events, cfg.KeyExpiryWarning)
+
+	if cfg.WebhooksFile != "" {
+		endpoints, err := webhook.LoadEndpoints(cfg.WebhooksFile)
+		if err != nil {
+			log.Fatal("Failed to load webhooks: %s", err)
+		}
+
+		dispatcher, err := webhook.NewDispatcher(cfg.WorkDir, endpoints)
+		if err != nil {
+			log.Fatal("Failed to load webhook queue: %s", err)
+		}
+
+		srv.events.Subscribe(dispatcher.Enqueue)
+		go dispatcher.Run(context.Background())
+	}
+
 	agent := tsnet.NewAgent(cfg)
 	defer agent.Shutdown()
 
-	agent.Connect()
+	agent.OnNetMap(func(nm *netmap.NetworkMap) {
+		if err := tracker.Observe(nm, time.Now()); err != nil {
+			log.Error("Failed to record tailnet changes: %s", err)
+		}
+	})
+	agent.OnNetMap(inventory.NewWriter(cfg.WorkDir).Observe)
+	agent.OnNetMap(srv.nodes.Observe)
+
+	if cfg.AlertsFile != "" {
+		rules, err := alerts.LoadRules(cfg.AlertsFile)
+		if err != nil {
+			log.Fatal("Failed to load alert rules: %s", err)
+		}
+
+		srv.alerts = alerts.NewEngine(rules, agent.Running, srv.recordAlert)
+		agent.OnNetMap(srv.alerts.Observe)
+		go srv.alerts.Run(context.Background(), 30*time.Second)
+	}
+
+	if cfg.ProbesFile != "" {
+		probes, err := probe.Load(cfg.ProbesFile)
+		if err != nil {
+			log.Fatal("Failed to load probes: %s", err)
+		}
+
+		srv.probes = probe.NewRunner(probes, agent)
+		agent.OnNetMap(srv.probes.Observe)
+	}
+
+	if cfg.CertsFile != "" {
+		certCfg, err := certs.Load(cfg.CertsFile)
+		if err != nil {
+			log.Fatal("Failed to load certificate targets: %s", err)
+		}
+
+		srv.certs = certs.NewMonitor(certCfg, agent, srv.recordCertExpiring)
+		agent.OnNetMap(srv.certs.Observe)
+	}
+
+	if cfg.SubnetsFile != "" {
+		if !cfg.AcceptRoutes {
+			log.Fatal("Subnet verification requires %s=true", config.AcceptRoutesEnv)
+		}
+
+		subnetCfg, err := subnets.Load(cfg.SubnetsFile)
+		if err != nil {
+			log.Fatal("Failed to load subnet targets: %s", err)
+		}
+
+		srv.subnets = subnets.NewChecker(subnetCfg, agent, agent)
+		agent.OnNetMap(srv.subnets.Observe)
+	}
+
+	if cfg.SDFile != "" {
+		sdCfg, err := promsd.Load(cfg.SDFile)
+		if err != nil {
+			log.Fatal("Failed to load service discovery config: %s", err)
+		}
+
+		sd := promsd.New(sdCfg)
+		agent.OnNetMap(sd.Observe)
+		metrics.Handle("/sd", sd)
+
+		// The http_sd endpoint lives on the metrics listener, so without one
+		// only fileSD is written.
+		if cfg.MetricsListen == "" {
+			log.Info("Service discovery over http_sd is disabled because %s is not set", config.MetricsListenEnv)
+		}
+	}
+
+	if cfg.WebProxyListen != "" {
+		srv.web = webproxy.New(agent)
+		agent.OnNetMap(srv.web.Observe)
+	}
+
+	var zones *zone.Exporter
+	if cfg.ZoneDir != "" {
+		zones = zone.NewExporter(cfg.ZoneDir, cfg.ZoneNS, cfg.ZoneTXT)
+		agent.OnNetMap(zones.Observe)
+	}
+
+	agent.Connect(context.Background())
+	srv.agent = agent
+
+	if srv.probes != nil {
+		go srv.probes.Run(context.Background())
+	}
+
+	if srv.certs != nil {
+		go srv.certs.Run(context.Background())
+	}
+
+	if zones != nil {
+		go zones.Run(context.Background(), cfg.ZoneInterval)
+	}
+
+	if err := agent.ClearExitNode(context.Background()); err != nil {
+		log.Fatal("Failed to clear exit node: %s", err)
+	}
+
+	if err := agent.SetAcceptRoutes(context.Background(), cfg.AcceptRoutes); err != nil {
+		log.Fatal("Failed to set subnet route preference: %s", err)
+	}
+
+	if srv.subnets != nil {
+		go srv.subnets.Run(context.Background())
+	}
+
+	if cfg.MetricsListen != "" {
+		userMetrics := http.HandlerFunc(agent.Sys().UserMetricsRegistry().Handler)
+		if err := metrics.Serve(cfg.MetricsListen, userMetrics); err != nil {
+			log.Fatal("Failed to start metrics server: %s", err)
+		}
+	}
+
+	if cfg.ProxyListen != "" {
+		rules, err := proxy.ParseAllowlist(cfg.ProxyAllow)
+		if err != nil {
+			log.Fatal("Failed to parse proxy allowlist: %s", err)
+		}
+
+		ln, err := proxy.Listen(cfg.ProxyListen)
+		if err != nil {
+			log.Fatal("Failed to start proxy: %s", err)
+		}
+
+		go func() {
+			if err := proxy.New(agent, cfg.ProxyPassword, rules).Serve(ln); err != nil {
+				log.Error("Proxy stopped: %s", err)
+			}
+		}()
+
+		log.Info("Serving tailnet proxy on %s", ln.Addr())
+	}
+
+	if srv.web != nil {
+		ln, err := proxy.Listen(cfg.WebProxyListen)
+		if err != nil {
+			log.Fatal("Failed to start web proxy: %s", err)
+		}
+
+		go func() {
+			log.Error("Web proxy stopped: %s", http.Serve(ln, srv.web))
+		}()
+
+		log.Info("Serving node web proxy on %s", ln.Addr())
+	}
+
+	if cfg.ServeUpstream != "" {
+		upstream, err := url.Parse(cfg.ServeUpstream)
+		if err != nil {
+			log.Fatal("Invalid Headplane upstream: %s", err)
+		}
+
+		h := serve.Handler(upstream, agent.Lc)
+		if err := serve.Start(agent, h, cfg.ServeListen, cfg.ServeTLSListen, cfg.ServeTLSCert, cfg.ServeTLSKey); err != nil {
+			log.Fatal("Failed to serve Headplane on the tailnet: %s", err)
+		}
+	}
+
+	if cfg.OIDCFile != "" {
+		oidcCfg, err := oidc.Load(cfg.OIDCFile)
+		if err != nil {
+			log.Fatal("Failed to load OIDC config: %s", err)
+		}
+
+		provider, err := oidc.New(oidcCfg, agent, cfg.WorkDir)
+		if err != nil {
+			log.Fatal("Failed to load OIDC signing key: %s", err)
+		}
+
+		ln, err := agent.Listen("tcp", oidcCfg.Listen)
+		if err != nil {
+			log.Fatal("Failed to start OIDC provider: %s", err)
+		}
+
+		go func() {
+			log.Error("OIDC provider stopped: %s", provider.Serve(ln))
+		}()
+
+		log.Info("Serving OIDC provider at %s", oidcCfg.Issuer)
+	}
 
 	enc := json.NewEncoder(os.Stdout)
 	scanner := bufio.NewScanner(os.Stdin)
@@ -45,20 +305,12 @@ func main() {
 	go func() {
 		<-sigCh
 		agent.Shutdown()
+		shutdownTracing(context.Background())
 		os.Exit(0)
 	}()
 
-	// Each line on stdin triggers a sync. The line content is ignored.
+	// Each line on stdin is a request. See parseRequest for the format.
 	for scanner.Scan() {
-		hosts, err := agent.FetchAllHostInfo(context.Background())
-		if err != nil {
-			enc.Encode(errorOutput{Error: err.Error()})
-			continue
-		}
-
-		enc.Encode(output{
-			Self:  agent.ID,
-			Hosts: hosts,
-		})
+		enc.Encode(srv.handle(context.Background(), scanner.Text()))
 	}
 }
@@ -0,0 +1,404 @@
+package alerts
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/tsnet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// Node is the state of a node that alert rules are evaluated against.
+type Node struct {
+	ID        string
+	Name      string
+	Tags      []string
+	Online    bool
+	LastSeen  time.Time
+	KeyExpiry time.Time
+	Active    bool
+	Relayed   bool
+}
+
+// NodesFrom converts fetched nodes into alert inputs.
+func NodesFrom(nodes map[string]*tsnet.Node) []Node {
+	out := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		st := n.Status
+		an := Node{
+			ID:       string(st.ID),
+			Name:     strings.TrimSuffix(st.DNSName, "."),
+			Tags:     n.Node.Tags,
+			Online:   st.Online,
+			LastSeen: st.LastSeen,
+			Active:   st.Active,
+			Relayed:  st.Active && st.CurAddr == "" && st.Relay != "",
+		}
+
+		if st.KeyExpiry != nil {
+			an.KeyExpiry = *st.KeyExpiry
+		}
+
+		out = append(out, an)
+	}
+
+	return out
+}
+
+// NodesFromNetMap converts the nodes in nm into alert inputs. The netmap
+// has no connection details, so Active and Relayed are carried over from
+// prev, as is the online state when control does not report it.
+func NodesFromNetMap(nm *netmap.NetworkMap, prev []Node) []Node {
+	byID := make(map[string]Node, len(prev))
+	for _, n := range prev {
+		byID[n.ID] = n
+	}
+
+	views := nm.Peers
+	if nm.SelfNode.Valid() {
+		views = append([]tailcfg.NodeView{nm.SelfNode}, views...)
+	}
+
+	out := make([]Node, 0, len(views))
+	for _, v := range views {
+		p := byID[string(v.StableID())]
+		an := Node{
+			ID:        string(v.StableID()),
+			Name:      strings.TrimSuffix(v.Name(), "."),
+			Tags:      v.Tags().AsSlice(),
+			Online:    v.Online().GetOr(p.Online),
+			LastSeen:  p.LastSeen,
+			KeyExpiry: v.KeyExpiry(),
+			Active:    p.Active,
+			Relayed:   p.Relayed,
+		}
+
+		if ls, ok := v.LastSeen().GetOk(); ok {
+			an.LastSeen = ls
+		}
+
+		out = append(out, an)
+	}
+
+	return out
+}
+
+// State is whether an alert is currently firing.
+type State string
+
+const (
+	Firing   State = "firing"
+	Resolved State = "resolved"
+)
+
+// Frame describes an alert changing state. Node is empty for alerts that
+// apply to the whole tailnet.
+type Frame struct {
+	Rule    string    `json:"rule"`
+	Node    string    `json:"node,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	State   State     `json:"state"`
+	Since   time.Time `json:"since"`
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
+}
+
+const maxFrames = 1000
+
+type instance struct {
+	pendingSince time.Time
+	clearSince   time.Time
+	firing       bool
+	firedAt      time.Time
+	name         string
+	message      string
+}
+
+// Engine evaluates alert rules against the latest node snapshot and keeps
+// track of which alerts are firing.
+type Engine struct {
+	rules     []Rule
+	controlOK func() bool
+	onFrame   func(Frame)
+
+	mu        sync.Mutex
+	nodes     []Node
+	instances map[string]*instance
+	frames    []Frame
+}
+
+// NewEngine creates an engine for rules. controlOK reports whether the agent
+// is connected to control, and onFrame is called for every state change.
+func NewEngine(rules []Rule, controlOK func() bool, onFrame func(Frame)) *Engine {
+	return &Engine{
+		rules:     rules,
+		controlOK: controlOK,
+		onFrame:   onFrame,
+		instances: make(map[string]*instance),
+	}
+}
+
+// Update replaces the node snapshot and evaluates every rule.
+func (e *Engine) Update(nodes []Node, now time.Time) {
+	e.mu.Lock()
+	e.nodes = nodes
+	e.mu.Unlock()
+
+	e.Evaluate(now)
+}
+
+// Observe evaluates every rule against nm so that alerts follow control's
+// view of the tailnet between syncs.
+func (e *Engine) Observe(nm *netmap.NetworkMap) {
+	e.mu.Lock()
+	e.nodes = NodesFromNetMap(nm, e.nodes)
+	e.mu.Unlock()
+
+	e.Evaluate(time.Now())
+}
+
+// Run re-evaluates the rules every interval so that durations elapse even
+// when no syncs arrive.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.Evaluate(now)
+		}
+	}
+}
+
+// condition is a single evaluated rule instance. since is when the
+// condition started holding, if known.
+type condition struct {
+	key     string
+	node    string
+	name    string
+	since   time.Time
+	message string
+}
+
+// Evaluate checks every rule and records firing and resolved transitions.
+func (e *Engine) Evaluate(now time.Time) {
+	controlOK := e.controlOK()
+
+	e.mu.Lock()
+	var frames []Frame
+	active := make(map[string]bool)
+	for _, r := range e.rules {
+		for _, c := range e.conditions(r, controlOK, now) {
+			active[c.key] = true
+			if f, ok := e.hold(r, c, now); ok {
+				frames = append(frames, f)
+			}
+		}
+	}
+
+	for key, inst := range e.instances {
+		if active[key] {
+			continue
+		}
+
+		rule, node, _ := strings.Cut(key, "\x00")
+		r := e.rule(rule)
+		if f, ok := e.clear(r, key, node, inst, now); ok {
+			frames = append(frames, f)
+		}
+	}
+
+	// Keep a bounded backlog in case nobody is draining frames.
+	e.frames = append(e.frames, frames...)
+	if len(e.frames) > maxFrames {
+		e.frames = e.frames[len(e.frames)-maxFrames:]
+	}
+	e.mu.Unlock()
+
+	for _, f := range frames {
+		e.onFrame(f)
+	}
+}
+
+func (e *Engine) rule(name string) Rule {
+	for _, r := range e.rules {
+		if r.Name == name {
+			return r
+		}
+	}
+
+	return Rule{Name: name}
+}
+
+// hold records that c is active and fires it once it has held for the
+// rule's duration.
+func (e *Engine) hold(r Rule, c condition, now time.Time) (Frame, bool) {
+	inst, ok := e.instances[c.key]
+	if !ok {
+		inst = &instance{}
+		e.instances[c.key] = inst
+	}
+
+	inst.clearSince = time.Time{}
+	inst.name, inst.message = c.name, c.message
+	if inst.pendingSince.IsZero() {
+		inst.pendingSince = cmp.Or(c.since, now)
+	}
+
+	if inst.firing || now.Sub(inst.pendingSince) < time.Duration(r.For) {
+		return Frame{}, false
+	}
+
+	inst.firing = true
+	inst.firedAt = now
+	return Frame{
+		Rule:    r.Name,
+		Node:    c.node,
+		Name:    c.name,
+		State:   Firing,
+		Since:   inst.pendingSince,
+		At:      now,
+		Message: c.message,
+	}, true
+}
+
+// clear records that an instance is no longer active and resolves it once
+// it has stayed clear for the rule's ClearFor.
+func (e *Engine) clear(r Rule, key, node string, inst *instance, now time.Time) (Frame, bool) {
+	if !inst.firing {
+		delete(e.instances, key)
+		return Frame{}, false
+	}
+
+	if inst.clearSince.IsZero() {
+		inst.clearSince = now
+	}
+
+	if now.Sub(inst.clearSince) < time.Duration(r.ClearFor) {
+		return Frame{}, false
+	}
+
+	delete(e.instances, key)
+	return Frame{
+		Rule:    r.Name,
+		Node:    node,
+		Name:    inst.name,
+		State:   Resolved,
+		Since:   inst.firedAt,
+		At:      now,
+		Message: inst.message,
+	}, true
+}
+
+func (e *Engine) conditions(r Rule, controlOK bool, now time.Time) []condition {
+	var out []condition
+	nodeCond := func(n Node, since time.Time, msg string) {
+		out = append(out, condition{
+			key:     r.Name + "\x00" + n.ID,
+			node:    n.ID,
+			name:    n.Name,
+			since:   since,
+			message: msg,
+		})
+	}
+
+	switch r.Kind {
+	case NodeOffline:
+		for _, n := range e.nodes {
+			if !n.Online && matchesTags(r.Tags, n.Tags) {
+				nodeCond(n, n.LastSeen, fmt.Sprintf("%s is offline", n.Name))
+			}
+		}
+
+	case KeyExpiry:
+		for _, n := range e.nodes {
+			if !n.KeyExpiry.IsZero() && n.KeyExpiry.Sub(now) < time.Duration(r.Within) && matchesTags(r.Tags, n.Tags) {
+				nodeCond(n, time.Time{}, fmt.Sprintf("%s key expires at %s", n.Name, n.KeyExpiry.Format(time.RFC3339)))
+			}
+		}
+
+	case DERPRatio:
+		var active, relayed int
+		for _, n := range e.nodes {
+			if n.Active {
+				active++
+				if n.Relayed {
+					relayed++
+				}
+			}
+		}
+
+		if active > 0 && float64(relayed)/float64(active) > r.Threshold {
+			out = append(out, condition{
+				key:     r.Name,
+				message: fmt.Sprintf("%d of %d active peers are relayed through DERP", relayed, active),
+			})
+		}
+
+	case ControlLost:
+		if !controlOK {
+			out = append(out, condition{key: r.Name, message: "agent is not connected to control"})
+		}
+	}
+
+	return out
+}
+
+func matchesTags(want, have []string) bool {
+	if len(want) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(want, func(t string) bool {
+		return slices.Contains(have, t)
+	})
+}
+
+// Drain returns the frames recorded since the last call.
+func (e *Engine) Drain() []Frame {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	frames := e.frames
+	e.frames = nil
+	return frames
+}
+
+// Active returns a frame for every alert that is currently firing.
+func (e *Engine) Active() []Frame {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := []Frame{}
+	for key, inst := range e.instances {
+		if !inst.firing {
+			continue
+		}
+
+		rule, node, _ := strings.Cut(key, "\x00")
+		out = append(out, Frame{
+			Rule:    rule,
+			Node:    node,
+			Name:    inst.name,
+			State:   Firing,
+			Since:   inst.pendingSince,
+			At:      inst.firedAt,
+			Message: inst.message,
+		})
+	}
+
+	slices.SortFunc(out, func(a, b Frame) int {
+		return cmp.Or(cmp.Compare(a.Rule, b.Rule), cmp.Compare(a.Node, b.Node))
+	})
+
+	return out
+}
@@ -0,0 +1,193 @@
+package alerts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestEngineEvaluate(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	offline := Node{ID: "1", Name: "db", Tags: []string{"tag:prod"}, LastSeen: t0}
+	online := Node{ID: "1", Name: "db", Tags: []string{"tag:prod"}, Online: true}
+
+	type step struct {
+		at        time.Duration
+		nodes     []Node
+		controlOK bool
+		want      []State
+	}
+
+	tests := []struct {
+		name  string
+		rule  Rule
+		steps []step
+	}{
+		{
+			name: "offline fires after for",
+			rule: Rule{Name: "r", Kind: NodeOffline, For: Duration(10 * time.Minute)},
+			steps: []step{
+				{at: 5 * time.Minute, nodes: []Node{offline}},
+				{at: 10 * time.Minute, nodes: []Node{offline}, want: []State{Firing}},
+				{at: 15 * time.Minute, nodes: []Node{offline}},
+			},
+		},
+		{
+			name: "offline resolves after clearFor",
+			rule: Rule{Name: "r", Kind: NodeOffline, ClearFor: Duration(5 * time.Minute)},
+			steps: []step{
+				{at: 0, nodes: []Node{offline}, want: []State{Firing}},
+				{at: time.Minute, nodes: []Node{online}},
+				{at: 6 * time.Minute, nodes: []Node{online}, want: []State{Resolved}},
+			},
+		},
+		{
+			name: "offline ignores other tags",
+			rule: Rule{Name: "r", Kind: NodeOffline, Tags: []string{"tag:dev"}},
+			steps: []step{
+				{at: 0, nodes: []Node{offline}},
+			},
+		},
+		{
+			name: "key expiry within window",
+			rule: Rule{Name: "r", Kind: KeyExpiry, Within: Duration(24 * time.Hour)},
+			steps: []step{
+				{at: 0, nodes: []Node{{ID: "1", Online: true, KeyExpiry: t0.Add(48 * time.Hour)}}},
+				{at: 25 * time.Hour, nodes: []Node{{ID: "1", Online: true, KeyExpiry: t0.Add(48 * time.Hour)}}, want: []State{Firing}},
+			},
+		},
+		{
+			name: "derp ratio",
+			rule: Rule{Name: "r", Kind: DERPRatio, Threshold: 0.4},
+			steps: []step{
+				{at: 0, nodes: []Node{
+					{ID: "1", Online: true, Active: true, Relayed: true},
+					{ID: "2", Online: true, Active: true},
+				}, want: []State{Firing}},
+				{at: time.Minute, nodes: []Node{
+					{ID: "1", Online: true, Active: true},
+					{ID: "2", Online: true, Active: true},
+				}, want: []State{Resolved}},
+			},
+		},
+		{
+			name: "control lost",
+			rule: Rule{Name: "r", Kind: ControlLost},
+			steps: []step{
+				{at: 0, controlOK: true},
+				{at: time.Minute, want: []State{Firing}},
+				{at: 2 * time.Minute, controlOK: true, want: []State{Resolved}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var controlOK bool
+			var frames []Frame
+			e := NewEngine([]Rule{tt.rule}, func() bool { return controlOK }, func(f Frame) {
+				frames = append(frames, f)
+			})
+
+			for i, st := range tt.steps {
+				frames = nil
+				controlOK = st.controlOK
+				e.Update(st.nodes, t0.Add(st.at))
+
+				var got []State
+				for _, f := range frames {
+					got = append(got, f.State)
+				}
+
+				if !reflect.DeepEqual(got, st.want) {
+					t.Errorf("step %d: frames = %v, want %v", i, got, st.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNodesFromNetMap(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	yes, no := true, false
+
+	tests := []struct {
+		name string
+		node *tailcfg.Node
+		prev []Node
+		want Node
+	}{
+		{
+			name: "new node",
+			node: &tailcfg.Node{StableID: "1", Name: "db.ts.net.", Tags: []string{"tag:prod"}, Online: &no, LastSeen: &t0},
+			want: Node{ID: "1", Name: "db.ts.net", Tags: []string{"tag:prod"}, LastSeen: t0},
+		},
+		{
+			name: "connection details are carried over",
+			node: &tailcfg.Node{StableID: "1", Name: "db.", Online: &yes},
+			prev: []Node{{ID: "1", Online: false, Active: true, Relayed: true, LastSeen: t0}},
+			want: Node{ID: "1", Name: "db", Online: true, Active: true, Relayed: true, LastSeen: t0},
+		},
+		{
+			name: "unknown online state is carried over",
+			node: &tailcfg.Node{StableID: "1", Name: "db."},
+			prev: []Node{{ID: "1", Online: true}},
+			want: Node{ID: "1", Name: "db", Online: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nm := &netmap.NetworkMap{Peers: []tailcfg.NodeView{tt.node.View()}}
+			got := NodesFromNetMap(nm, tt.prev)
+			if len(got) != 1 {
+				t.Fatalf("got %d nodes, want 1", len(got))
+			}
+
+			if len(got[0].Tags) == 0 {
+				got[0].Tags = nil
+			}
+
+			if !reflect.DeepEqual(got[0], tt.want) {
+				t.Errorf("node = %+v, want %+v", got[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineObserve(t *testing.T) {
+	no := false
+	rules := []Rule{{Name: "offline", Kind: NodeOffline}}
+
+	tests := []struct {
+		name string
+		nm   *netmap.NetworkMap
+		want int
+	}{
+		{
+			name: "offline peer fires without a sync",
+			nm: &netmap.NetworkMap{Peers: []tailcfg.NodeView{
+				(&tailcfg.Node{StableID: "1", Name: "db.", Online: &no}).View(),
+			}},
+			want: 1,
+		},
+		{
+			name: "empty netmap",
+			nm:   &netmap.NetworkMap{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEngine(rules, func() bool { return true }, func(Frame) {})
+			e.Observe(tt.nm)
+
+			if got := len(e.Active()); got != tt.want {
+				t.Errorf("got %d active alerts, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Kind selects the condition a rule checks.
+type Kind string
+
+const (
+	// NodeOffline holds while a matching node is offline.
+	NodeOffline Kind = "node_offline"
+	// KeyExpiry holds while a matching node's key expires within Within.
+	KeyExpiry Kind = "key_expiry"
+	// DERPRatio holds while more than Threshold of active peers are relayed.
+	DERPRatio Kind = "derp_ratio"
+	// ControlLost holds while the agent is not connected to control.
+	ControlLost Kind = "control_lost"
+)
+
+// Duration is a time.Duration that is written as a string such as "10m"
+// in the rules file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule is a condition that must hold for For before the alert fires and
+// must stop holding for ClearFor before it resolves.
+type Rule struct {
+	Name      string   `json:"name"`
+	Kind      Kind     `json:"kind"`
+	Tags      []string `json:"tags,omitempty"`
+	For       Duration `json:"for,omitempty"`
+	ClearFor  Duration `json:"clearFor,omitempty"`
+	Within    Duration `json:"within,omitempty"`
+	Threshold float64  `json:"threshold,omitempty"`
+}
+
+// LoadRules reads and validates alert rules from a JSON file.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+
+	var file struct {
+		Rules []Rule `json:"rules"`
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, r := range file.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("alert rule %d has no name", i)
+		}
+
+		if seen[r.Name] {
+			return nil, fmt.Errorf("duplicate alert rule %q", r.Name)
+		}
+		seen[r.Name] = true
+
+		switch r.Kind {
+		case NodeOffline, ControlLost:
+		case KeyExpiry:
+			if r.Within <= 0 {
+				return nil, fmt.Errorf("alert rule %q needs a positive within", r.Name)
+			}
+		case DERPRatio:
+			if r.Threshold <= 0 || r.Threshold >= 1 {
+				return nil, fmt.Errorf("alert rule %q needs a threshold between 0 and 1", r.Name)
+			}
+		default:
+			return nil, fmt.Errorf("alert rule %q has unknown kind %q", r.Name, r.Kind)
+		}
+	}
+
+	return file.Rules, nil
+}
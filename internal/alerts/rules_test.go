@@ -0,0 +1,79 @@
+package alerts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantFor time.Duration
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			config:  `{"rules":[{"name":"a","kind":"node_offline","for":"10m"}]}`,
+			wantFor: 10 * time.Minute,
+		},
+		{
+			name:    "missing name",
+			config:  `{"rules":[{"kind":"node_offline"}]}`,
+			wantErr: "has no name",
+		},
+		{
+			name:    "duplicate name",
+			config:  `{"rules":[{"name":"a","kind":"control_lost"},{"name":"a","kind":"control_lost"}]}`,
+			wantErr: "duplicate",
+		},
+		{
+			name:    "key expiry without within",
+			config:  `{"rules":[{"name":"a","kind":"key_expiry"}]}`,
+			wantErr: "positive within",
+		},
+		{
+			name:    "derp ratio out of range",
+			config:  `{"rules":[{"name":"a","kind":"derp_ratio","threshold":1.5}]}`,
+			wantErr: "threshold",
+		},
+		{
+			name:    "unknown kind",
+			config:  `{"rules":[{"name":"a","kind":"cpu"}]}`,
+			wantErr: "unknown kind",
+		},
+		{
+			name:    "invalid duration",
+			config:  `{"rules":[{"name":"a","kind":"node_offline","for":"soon"}]}`,
+			wantErr: "failed to parse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "alerts.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			rules, err := LoadRules(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := time.Duration(rules[0].For); got != tt.wantFor {
+				t.Errorf("For = %s, want %s", got, tt.wantFor)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package certs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Config lists the TLS endpoints to watch. Targets are host:port pairs where
+// the host is a MagicDNS name, a short node name or a Tailscale IP.
+type Config struct {
+	Targets    []string
+	Interval   time.Duration
+	WarnBefore time.Duration
+}
+
+// Load reads and validates a certificate monitoring config from a JSON file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate targets: %w", err)
+	}
+
+	var raw struct {
+		Targets    []string `json:"targets"`
+		Interval   string   `json:"interval"`
+		WarnBefore string   `json:"warnBefore"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse certificate targets: %w", err)
+	}
+
+	c := &Config{
+		Targets:    raw.Targets,
+		Interval:   6 * time.Hour,
+		WarnBefore: 14 * 24 * time.Hour,
+	}
+
+	for _, t := range c.Targets {
+		if _, _, err := net.SplitHostPort(t); err != nil {
+			return nil, fmt.Errorf("invalid certificate target %q: %w", t, err)
+		}
+	}
+
+	if raw.Interval != "" {
+		if c.Interval, err = time.ParseDuration(raw.Interval); err != nil {
+			return nil, fmt.Errorf("invalid interval: %w", err)
+		}
+
+		if c.Interval <= 0 {
+			return nil, fmt.Errorf("invalid interval: %s is not positive", raw.Interval)
+		}
+	}
+
+	if raw.WarnBefore != "" {
+		if c.WarnBefore, err = time.ParseDuration(raw.WarnBefore); err != nil {
+			return nil, fmt.Errorf("invalid warnBefore: %w", err)
+		}
+
+		if c.WarnBefore <= 0 {
+			return nil, fmt.Errorf("invalid warnBefore: %s is not positive", raw.WarnBefore)
+		}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,77 @@
+package certs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         string
+		wantInterval   time.Duration
+		wantWarnBefore time.Duration
+		wantErr        string
+	}{
+		{
+			name:           "defaults",
+			config:         `{"targets":["web:443"]}`,
+			wantInterval:   6 * time.Hour,
+			wantWarnBefore: 14 * 24 * time.Hour,
+		},
+		{
+			name:           "explicit durations",
+			config:         `{"targets":["web:443"],"interval":"1h","warnBefore":"72h"}`,
+			wantInterval:   time.Hour,
+			wantWarnBefore: 72 * time.Hour,
+		},
+		{
+			name:    "target without port",
+			config:  `{"targets":["web"]}`,
+			wantErr: "invalid certificate target",
+		},
+		{
+			name:    "zero interval",
+			config:  `{"targets":["web:443"],"interval":"0s"}`,
+			wantErr: "invalid interval",
+		},
+		{
+			name:    "negative interval",
+			config:  `{"targets":["web:443"],"interval":"-1h"}`,
+			wantErr: "invalid interval",
+		},
+		{
+			name:    "zero warnBefore",
+			config:  `{"targets":["web:443"],"warnBefore":"0s"}`,
+			wantErr: "invalid warnBefore",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "certs.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.Interval != tt.wantInterval || c.WarnBefore != tt.wantWarnBefore {
+				t.Errorf("got interval %s, warnBefore %s, want %s, %s", c.Interval, c.WarnBefore, tt.wantInterval, tt.wantWarnBefore)
+			}
+		})
+	}
+}
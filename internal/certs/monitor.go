@@ -0,0 +1,292 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/metrics"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/types/netmap"
+)
+
+// Dialer opens connections over the tailnet. *tsnet.Server satisfies it.
+type Dialer interface {
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Cert describes one certificate in a presented chain.
+type Cert struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	SANs      []string  `json:"sans"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// Report is the result of the latest check of a target. Node and Name are
+// only set when the target host belongs to a node in the netmap.
+type Report struct {
+	Target       string    `json:"target"`
+	Node         string    `json:"node,omitempty"`
+	Name         string    `json:"name,omitempty"`
+	Chain        []Cert    `json:"chain"`
+	NotAfter     time.Time `json:"notAfter,omitzero"`
+	Expiring     bool      `json:"expiring"`
+	NameMismatch bool      `json:"nameMismatch"`
+	Error        string    `json:"error,omitempty"`
+	CheckedAt    time.Time `json:"checkedAt"`
+}
+
+// node is the subset of a netmap node needed to resolve a target.
+type node struct {
+	id   string
+	name string
+	addr netip.Addr
+}
+
+// Monitor periodically fetches the certificates presented by every target
+// and reports those that are close to expiry or don't match the node.
+type Monitor struct {
+	cfg    *Config
+	dial   Dialer
+	onWarn func(Report)
+
+	// ready is closed by the first netmap, before which targets can't be
+	// resolved to nodes.
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu      sync.Mutex
+	nodes   []node
+	reports map[string]*Report
+	warned  map[string]bool // target + leaf serial
+}
+
+// NewMonitor returns a monitor that dials through d and calls onWarn the
+// first time a certificate is found within the warning window.
+func NewMonitor(cfg *Config, d Dialer, onWarn func(Report)) *Monitor {
+	return &Monitor{
+		cfg:     cfg,
+		dial:    d,
+		onWarn:  onWarn,
+		ready:   make(chan struct{}),
+		reports: make(map[string]*Report),
+		warned:  make(map[string]bool),
+	}
+}
+
+// Observe updates the nodes used to resolve target hosts.
+func (m *Monitor) Observe(nm *netmap.NetworkMap) {
+	var nodes []node
+	for _, n := range nm.Peers {
+		if !n.Valid() {
+			continue
+		}
+
+		for _, p := range n.Addresses().All() {
+			nodes = append(nodes, node{
+				id:   string(n.StableID()),
+				name: strings.TrimSuffix(n.Name(), "."),
+				addr: p.Addr(),
+			})
+		}
+	}
+
+	m.mu.Lock()
+	m.nodes = nodes
+	m.mu.Unlock()
+
+	m.readyOnce.Do(func() { close(m.ready) })
+}
+
+// Run checks every target on the configured interval until ctx is done.
+// The first check waits for the first netmap so that node names resolve.
+func (m *Monitor) Run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-m.ready:
+	}
+
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.CheckAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every target once.
+func (m *Monitor) CheckAll(ctx context.Context) {
+	const maxParallel = 8
+	sema := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for _, target := range m.cfg.Targets {
+		wg.Add(1)
+		sema <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sema }()
+			m.record(m.check(ctx, target))
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Reports returns the latest report for every target, ordered by target.
+func (m *Monitor) Reports() []Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]Report, 0, len(m.reports))
+	for _, r := range m.reports {
+		out = append(out, *r)
+	}
+
+	slices.SortFunc(out, func(a, b Report) int { return strings.Compare(a.Target, b.Target) })
+	return out
+}
+
+func (m *Monitor) check(ctx context.Context, target string) *Report {
+	log := util.GetLogger()
+	report := &Report{Target: target, Chain: []Cert{}, CheckedAt: time.Now()}
+
+	host, port, _ := net.SplitHostPort(target)
+	addr := host
+	if n, ok := m.resolve(host); ok {
+		report.Node = n.id
+		report.Name = n.name
+		addr = n.addr.String()
+	}
+
+	serverName := host
+	if report.Name != "" {
+		serverName = report.Name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	chain, err := m.fetchChain(ctx, net.JoinHostPort(addr, port), serverName)
+	if err != nil {
+		log.Debug("Failed to fetch certificate for %s: %s", target, err)
+		report.Error = err.Error()
+		return report
+	}
+
+	for _, c := range chain {
+		report.Chain = append(report.Chain, Cert{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			SANs:      sans(c),
+			Serial:    hex.EncodeToString(c.SerialNumber.Bytes()),
+			NotBefore: c.NotBefore,
+			NotAfter:  c.NotAfter,
+		})
+	}
+
+	leaf := chain[0]
+	report.NotAfter = leaf.NotAfter
+	report.Expiring = time.Until(leaf.NotAfter) < m.cfg.WarnBefore
+	if report.Name != "" {
+		report.NameMismatch = leaf.VerifyHostname(report.Name) != nil
+	}
+
+	return report
+}
+
+// fetchChain completes a TLS handshake and returns the presented chain
+// without verifying it, so internal and expired certificates are reported.
+func (m *Monitor) fetchChain(ctx context.Context, addr, serverName string) ([]*x509.Certificate, error) {
+	conn, err := m.dial.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	defer tc.Close()
+
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	chain := tc.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, errors.New("no certificates presented")
+	}
+
+	return chain, nil
+}
+
+// resolve finds the node a target host refers to by IP, MagicDNS name or
+// short name.
+func (m *Monitor) resolve(host string) (node, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ip, _ := netip.ParseAddr(host)
+	host = strings.TrimSuffix(host, ".")
+	for _, n := range m.nodes {
+		short, _, _ := strings.Cut(n.name, ".")
+		if n.addr == ip || strings.EqualFold(n.name, host) || strings.EqualFold(short, host) {
+			return n, true
+		}
+	}
+
+	return node{}, false
+}
+
+func (m *Monitor) record(r *Report) {
+	m.mu.Lock()
+	m.reports[r.Target] = r
+
+	warn := false
+	if r.Expiring && len(r.Chain) > 0 {
+		key := r.Target + "/" + r.Chain[0].Serial
+		warn = !m.warned[key]
+		m.warned[key] = true
+	}
+	m.mu.Unlock()
+
+	if r.Error == "" {
+		metrics.CertNotAfter.SetInt(metrics.CertLabels{Target: r.Target}, r.NotAfter.Unix())
+	} else {
+		metrics.CertNotAfter.Delete(metrics.CertLabels{Target: r.Target})
+	}
+
+	if warn && m.onWarn != nil {
+		m.onWarn(*r)
+	}
+}
+
+func sans(c *x509.Certificate) []string {
+	names := slices.Clone(c.DNSNames)
+	for _, ip := range c.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	if names == nil {
+		return []string{}
+	}
+
+	return names
+}
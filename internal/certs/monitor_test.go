@@ -0,0 +1,178 @@
+package certs
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// hostDialer dials over the host network in place of the tailnet.
+type hostDialer struct{}
+
+func (hostDialer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// testNetMap returns a netmap with one peer named name on 127.0.0.1.
+func testNetMap(name string) *netmap.NetworkMap {
+	return &netmap.NetworkMap{Peers: []tailcfg.NodeView{
+		(&tailcfg.Node{
+			StableID:  "n1",
+			Name:      name + ".",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		}).View(),
+	}}
+}
+
+func TestCheckAll(t *testing.T) {
+	// httptest certificates are valid for example.com and 127.0.0.1 until
+	// 2084.
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	tests := []struct {
+		name         string
+		host         string
+		node         string
+		warnBefore   time.Duration
+		wantNode     string
+		wantExpiring bool
+		wantMismatch bool
+		wantWarnings int
+	}{
+		{
+			name:       "ip target outside the tailnet",
+			host:       "127.0.0.1",
+			warnBefore: 24 * time.Hour,
+		},
+		{
+			name:       "node name matches the certificate",
+			host:       "example",
+			node:       "example.com",
+			warnBefore: 24 * time.Hour,
+			wantNode:   "example.com",
+		},
+		{
+			name:         "node name does not match the certificate",
+			host:         "db",
+			node:         "db.tailnet.ts.net",
+			warnBefore:   24 * time.Hour,
+			wantNode:     "db.tailnet.ts.net",
+			wantMismatch: true,
+		},
+		{
+			name:         "expiring certificate warns once",
+			host:         "127.0.0.1",
+			warnBefore:   100 * 365 * 24 * time.Hour,
+			wantExpiring: true,
+			wantWarnings: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Targets:    []string{net.JoinHostPort(tt.host, port)},
+				Interval:   time.Hour,
+				WarnBefore: tt.warnBefore,
+			}
+
+			var warnings int
+			m := NewMonitor(cfg, hostDialer{}, func(Report) { warnings++ })
+			if tt.node != "" {
+				m.Observe(testNetMap(tt.node))
+			}
+
+			m.CheckAll(context.Background())
+			m.CheckAll(context.Background())
+
+			reports := m.Reports()
+			if len(reports) != 1 {
+				t.Fatalf("got %d reports, want 1", len(reports))
+			}
+
+			r := reports[0]
+			if r.Error != "" {
+				t.Fatalf("check failed: %s", r.Error)
+			}
+
+			if r.Name != tt.wantNode || r.Expiring != tt.wantExpiring || r.NameMismatch != tt.wantMismatch {
+				t.Errorf("got name %q, expiring %v, mismatch %v, want %q, %v, %v",
+					r.Name, r.Expiring, r.NameMismatch, tt.wantNode, tt.wantExpiring, tt.wantMismatch)
+			}
+
+			if warnings != tt.wantWarnings {
+				t.Errorf("got %d warnings, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestRunWaitsForNetMap(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	cfg := &Config{
+		Targets:    []string{net.JoinHostPort("db", port)},
+		Interval:   time.Hour,
+		WarnBefore: time.Hour,
+	}
+
+	tests := []struct {
+		name     string
+		observe  bool
+		wantName string
+	}{
+		{name: "no netmap yet"},
+		{name: "after the first netmap", observe: true, wantName: "db.tailnet.ts.net"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			m := NewMonitor(cfg, hostDialer{}, nil)
+			done := make(chan struct{})
+			go func() {
+				m.Run(ctx)
+				close(done)
+			}()
+
+			if tt.observe {
+				m.Observe(testNetMap("db.tailnet.ts.net"))
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for len(m.Reports()) == 0 && tt.observe && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if !tt.observe {
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			reports := m.Reports()
+			switch {
+			case !tt.observe && len(reports) != 0:
+				t.Errorf("checked %d targets before the first netmap", len(reports))
+			case tt.observe && (len(reports) != 1 || reports[0].Name != tt.wantName):
+				t.Errorf("reports = %+v, want one for %s", reports, tt.wantName)
+			}
+
+			cancel()
+			<-done
+		})
+	}
+}
@@ -1,45 +1,192 @@
 package config
 
-import "os"
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
 
 // Config represents the configuration for the agent.
 type Config struct {
-	Debug        bool
-	Hostname     string
-	TSControlURL string
-	TSAuthKey    string
-	WorkDir      string
+	Debug         bool
+	Hostname      string
+	TSControlURL  string
+	TSAuthKey     string
+	WorkDir       string
+	PostureFile   string
+	StaleAfter    time.Duration
+	MetricsListen string
+	OTLPEndpoint  string
+
+	// HistoryRetention is how long node history is kept. History is only
+	// recorded when it is set.
+	HistoryRetention time.Duration
+
+	WebhooksFile     string
+	KeyExpiryWarning time.Duration
+	AlertsFile       string
+	ProbesFile       string
+	CertsFile        string
+	SubnetsFile      string
+	AcceptRoutes     bool
+	EgressCheckURL   string
+	SDFile           string
+
+	// ZoneDir enables writing DNS zone files for the tailnet into it.
+	ZoneDir      string
+	ZoneInterval time.Duration
+	ZoneNS       string
+	ZoneTXT      bool
+
+	// ProxyListen enables the loopback SOCKS5 and HTTP CONNECT proxy.
+	ProxyListen   string
+	ProxyPassword string
+	ProxyAllow    []string
+
+	// ServeUpstream enables serving Headplane on the tailnet by proxying
+	// to it.
+	ServeUpstream  string
+	ServeListen    string
+	ServeTLSListen string
+	ServeTLSCert   string
+	ServeTLSKey    string
+
+	// OIDCFile enables the tailnet OIDC provider.
+	OIDCFile string
+
+	// WebProxyListen enables the loopback proxy to node web UIs.
+	WebProxyListen string
 }
 
 const (
-	DebugEnv        = "HEADPLANE_AGENT_DEBUG"
-	HostnameEnv     = "HEADPLANE_AGENT_HOSTNAME"
-	TSControlURLEnv = "HEADPLANE_AGENT_TS_SERVER"
-	TSAuthKeyEnv    = "HEADPLANE_AGENT_TS_AUTHKEY"
-	WorkDirEnv      = "HEADPLANE_AGENT_WORK_DIR"
+	DebugEnv            = "HEADPLANE_AGENT_DEBUG"
+	HostnameEnv         = "HEADPLANE_AGENT_HOSTNAME"
+	TSControlURLEnv     = "HEADPLANE_AGENT_TS_SERVER"
+	TSAuthKeyEnv        = "HEADPLANE_AGENT_TS_AUTHKEY"
+	WorkDirEnv          = "HEADPLANE_AGENT_WORK_DIR"
+	PostureFileEnv      = "HEADPLANE_AGENT_POSTURE_FILE"
+	StaleAfterEnv       = "HEADPLANE_AGENT_STALE_AFTER"
+	MetricsListenEnv    = "HEADPLANE_AGENT_METRICS_LISTEN"
+	OTLPEndpointEnv     = "HEADPLANE_AGENT_OTLP_ENDPOINT"
+	HistoryRetentionEnv = "HEADPLANE_AGENT_HISTORY_RETENTION"
+	WebhooksFileEnv     = "HEADPLANE_AGENT_WEBHOOKS_FILE"
+	KeyExpiryWarningEnv = "HEADPLANE_AGENT_KEY_EXPIRY_WARNING"
+	AlertsFileEnv       = "HEADPLANE_AGENT_ALERTS_FILE"
+	ProbesFileEnv       = "HEADPLANE_AGENT_PROBES_FILE"
+	CertsFileEnv        = "HEADPLANE_AGENT_CERTS_FILE"
+	SubnetsFileEnv      = "HEADPLANE_AGENT_SUBNETS_FILE"
+	AcceptRoutesEnv     = "HEADPLANE_AGENT_ACCEPT_ROUTES"
+	EgressCheckURLEnv   = "HEADPLANE_AGENT_EGRESS_CHECK_URL"
+	SDFileEnv           = "HEADPLANE_AGENT_SD_FILE"
+	ZoneDirEnv          = "HEADPLANE_AGENT_ZONE_DIR"
+	ZoneIntervalEnv     = "HEADPLANE_AGENT_ZONE_INTERVAL"
+	ZoneNSEnv           = "HEADPLANE_AGENT_ZONE_NS"
+	ZoneTXTEnv          = "HEADPLANE_AGENT_ZONE_TXT"
+	ProxyListenEnv      = "HEADPLANE_AGENT_PROXY_LISTEN"
+	ProxyPasswordEnv    = "HEADPLANE_AGENT_PROXY_PASSWORD"
+	ProxyAllowEnv       = "HEADPLANE_AGENT_PROXY_ALLOW"
+	ServeUpstreamEnv    = "HEADPLANE_AGENT_SERVE_UPSTREAM"
+	ServeListenEnv      = "HEADPLANE_AGENT_SERVE_LISTEN"
+	ServeTLSListenEnv   = "HEADPLANE_AGENT_SERVE_TLS_LISTEN"
+	ServeTLSCertEnv     = "HEADPLANE_AGENT_SERVE_TLS_CERT"
+	ServeTLSKeyEnv      = "HEADPLANE_AGENT_SERVE_TLS_KEY"
+	OIDCFileEnv         = "HEADPLANE_AGENT_OIDC_FILE"
+	WebProxyListenEnv   = "HEADPLANE_AGENT_WEB_PROXY_LISTEN"
 )
 
-// Load reads the agent configuration from environment variables.
+// Load reads the agent configuration from environment variables. It does
+// not contact the control server; see Preflight.
 func Load() (*Config, error) {
 	c := &Config{
-		Debug:        false,
-		Hostname:     os.Getenv(HostnameEnv),
-		TSControlURL: os.Getenv(TSControlURLEnv),
-		TSAuthKey:    os.Getenv(TSAuthKeyEnv),
-		WorkDir:      os.Getenv(WorkDirEnv),
+		Debug:            false,
+		Hostname:         os.Getenv(HostnameEnv),
+		TSControlURL:     os.Getenv(TSControlURLEnv),
+		TSAuthKey:        os.Getenv(TSAuthKeyEnv),
+		WorkDir:          os.Getenv(WorkDirEnv),
+		PostureFile:      os.Getenv(PostureFileEnv),
+		StaleAfter:       30 * 24 * time.Hour,
+		MetricsListen:    os.Getenv(MetricsListenEnv),
+		OTLPEndpoint:     os.Getenv(OTLPEndpointEnv),
+		WebhooksFile:     os.Getenv(WebhooksFileEnv),
+		KeyExpiryWarning: 7 * 24 * time.Hour,
+		AlertsFile:       os.Getenv(AlertsFileEnv),
+		ProbesFile:       os.Getenv(ProbesFileEnv),
+		CertsFile:        os.Getenv(CertsFileEnv),
+		SubnetsFile:      os.Getenv(SubnetsFileEnv),
+		AcceptRoutes:     os.Getenv(AcceptRoutesEnv) == "true",
+		EgressCheckURL:   os.Getenv(EgressCheckURLEnv),
+		SDFile:           os.Getenv(SDFileEnv),
+		ZoneDir:          os.Getenv(ZoneDirEnv),
+		ZoneInterval:     5 * time.Minute,
+		ZoneNS:           cmp.Or(os.Getenv(ZoneNSEnv), "localhost."),
+		ZoneTXT:          os.Getenv(ZoneTXTEnv) == "true",
+		ProxyListen:      os.Getenv(ProxyListenEnv),
+		ProxyPassword:    os.Getenv(ProxyPasswordEnv),
+		ServeUpstream:    os.Getenv(ServeUpstreamEnv),
+		ServeListen:      os.Getenv(ServeListenEnv),
+		ServeTLSListen:   os.Getenv(ServeTLSListenEnv),
+		ServeTLSCert:     os.Getenv(ServeTLSCertEnv),
+		ServeTLSKey:      os.Getenv(ServeTLSKeyEnv),
+		OIDCFile:         os.Getenv(OIDCFileEnv),
+		WebProxyListen:   os.Getenv(WebProxyListenEnv),
+	}
+
+	if v := os.Getenv(ProxyAllowEnv); v != "" {
+		c.ProxyAllow = strings.Split(v, ",")
 	}
 
 	if os.Getenv(DebugEnv) == "true" {
 		c.Debug = true
 	}
 
-	if err := validateRequired(c); err != nil {
+	if err := durationEnv(StaleAfterEnv, &c.StaleAfter); err != nil {
+		return nil, err
+	}
+
+	if err := durationEnv(HistoryRetentionEnv, &c.HistoryRetention); err != nil {
 		return nil, err
 	}
 
-	if err := validateTSReady(c); err != nil {
+	if err := durationEnv(KeyExpiryWarningEnv, &c.KeyExpiryWarning); err != nil {
+		return nil, err
+	}
+
+	if err := durationEnv(ZoneIntervalEnv, &c.ZoneInterval); err != nil {
+		return nil, err
+	}
+
+	if err := validateRequired(c); err != nil {
 		return nil, err
 	}
 
 	return c, nil
 }
+
+// Preflight makes sure the control server is reachable before connecting.
+func Preflight(ctx context.Context, c *Config) error {
+	return validateTSReady(ctx, c)
+}
+
+// durationEnv parses the positive duration in env into d, leaving the
+// default in place when the variable is unset.
+func durationEnv(env string, d *time.Duration) error {
+	v := os.Getenv(env)
+	if v == "" {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", env, err)
+	}
+
+	if parsed <= 0 {
+		return fmt.Errorf("invalid %s: %s is not positive", env, v)
+	}
+
+	*d = parsed
+	return nil
+}
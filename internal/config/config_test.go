@@ -0,0 +1,46 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationEnv(t *testing.T) {
+	const env = "HEADPLANE_AGENT_TEST_INTERVAL"
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr string
+	}{
+		{name: "unset keeps the default", value: "", want: time.Minute},
+		{name: "valid", value: "5m", want: 5 * time.Minute},
+		{name: "zero", value: "0s", wantErr: "0s is not positive"},
+		{name: "negative", value: "-1m", wantErr: "-1m is not positive"},
+		{name: "invalid", value: "soon", wantErr: "invalid " + env},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(env, tt.value)
+			d := time.Minute
+			err := durationEnv(env, &d)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("durationEnv error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("durationEnv: %v", err)
+			}
+
+			if d != tt.want {
+				t.Errorf("durationEnv = %s, want %s", d, tt.want)
+			}
+		})
+	}
+}
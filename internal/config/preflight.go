@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -23,6 +24,18 @@ func validateRequired(config *Config) error {
 		return fmt.Errorf("%s is required", WorkDirEnv)
 	}
 
+	if config.ProxyListen != "" && (config.ProxyPassword == "" || len(config.ProxyAllow) == 0) {
+		return fmt.Errorf("%s and %s are required for the proxy", ProxyPasswordEnv, ProxyAllowEnv)
+	}
+
+	if config.ServeUpstream != "" && config.ServeListen == "" && config.ServeTLSListen == "" {
+		return fmt.Errorf("%s or %s is required to serve Headplane", ServeListenEnv, ServeTLSListenEnv)
+	}
+
+	if config.ServeTLSListen != "" && (config.ServeTLSCert == "" || config.ServeTLSKey == "") {
+		return fmt.Errorf("%s and %s are required for HTTPS", ServeTLSCertEnv, ServeTLSKeyEnv)
+	}
+
 	if config.TSAuthKey == "" && !hasExistingState(config.WorkDir) {
 		return fmt.Errorf("%s is required for first run (no existing state in %s)", TSAuthKeyEnv, config.WorkDir)
 	}
@@ -37,14 +50,19 @@ func hasExistingState(workDir string) bool {
 }
 
 // Pings the Tailscale control server to make sure it's up and running
-func validateTSReady(config *Config) error {
+func validateTSReady(ctx context.Context, config *Config) error {
 	testURL := config.TSControlURL
 	if strings.HasSuffix(testURL, "/") {
 		testURL = testURL[:len(testURL)-1]
 	}
 
 	testURL = fmt.Sprintf("%s/key?v=116", testURL)
-	resp, err := http.Get(testURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", testURL, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to TS control server: %s", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("Failed to connect to TS control server: %s", err)
 	}
@@ -0,0 +1,36 @@
+package events
+
+import "time"
+
+// Type identifies the kind of change an event describes.
+type Type string
+
+const (
+	NodeAdded          Type = "node.added"
+	NodeRemoved        Type = "node.removed"
+	NodeRenamed        Type = "node.renamed"
+	NodeKeyRotated     Type = "node.key_rotated"
+	NodeTagsChanged    Type = "node.tags_changed"
+	NodeOSUpgraded     Type = "node.os_upgraded"
+	NodeClientUpgraded Type = "node.client_upgraded"
+	NodeRoutesChanged  Type = "node.routes_changed"
+	NodeOnline         Type = "node.online"
+	NodeOffline        Type = "node.offline"
+	NodeKeyExpiring    Type = "node.key_expiring"
+	NodePostureFailed  Type = "node.posture_failed"
+	CertExpiring       Type = "cert.expiring"
+	AlertFiring        Type = "alert.firing"
+	AlertResolved      Type = "alert.resolved"
+)
+
+// Event is a single change observed in the tailnet. Old and New hold the
+// changed value where that makes sense for the event type.
+type Event struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	Type Type      `json:"type"`
+	Node string    `json:"node"`
+	Name string    `json:"name"`
+	Old  any       `json:"old,omitempty"`
+	New  any       `json:"new,omitempty"`
+}
@@ -0,0 +1,234 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	logFile    = "events.jsonl"
+	rotateFile = "events.1.jsonl"
+
+	// maxLogSize is the size at which the log is rotated. Only one rotated
+	// file is kept, which bounds both disk usage and the cost of a query.
+	maxLogSize = 8 * 1024 * 1024
+)
+
+// Log is the append-only audit log of tailnet changes kept in the agent
+// work directory. Every appended event is also passed to subscribers.
+type Log struct {
+	path    string
+	old     string
+	maxSize int64
+	mu      sync.Mutex
+	lastID  uint64
+	subs    []func(Event)
+}
+
+// Open opens the event log in workDir and recovers the last event ID.
+func Open(workDir string) (*Log, error) {
+	l := &Log{
+		path:    filepath.Join(workDir, logFile),
+		old:     filepath.Join(workDir, rotateFile),
+		maxSize: maxLogSize,
+	}
+
+	err := l.scan(func(e Event) {
+		l.lastID = max(l.lastID, e.ID)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Subscribe registers fn to be called with every new event. It is called
+// synchronously, so slow subscribers should hand off to a goroutine.
+func (l *Log) Subscribe(fn func(Event)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.subs = append(l.subs, fn)
+}
+
+// Append assigns IDs to the events, writes them to the log and notifies
+// subscribers.
+func (l *Log) Append(evs ...Event) error {
+	if len(evs) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	if err := l.rotate(); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		l.mu.Unlock()
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range evs {
+		l.lastID++
+		evs[i].ID = l.lastID
+		if err := enc.Encode(evs[i]); err != nil {
+			f.Close()
+			l.mu.Unlock()
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+	}
+
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	subs := slices.Clone(l.subs)
+	l.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to write event log: %w", err)
+	}
+
+	for _, e := range evs {
+		for _, fn := range subs {
+			fn(e)
+		}
+	}
+
+	return nil
+}
+
+// rotate moves the log aside once it reaches maxSize, replacing the
+// previously rotated file.
+func (l *Log) rotate() error {
+	info, err := os.Stat(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to stat event log: %w", err)
+	}
+
+	if info.Size() < l.maxSize {
+		return nil
+	}
+
+	if err := os.Rename(l.path, l.old); err != nil {
+		return fmt.Errorf("failed to rotate event log: %w", err)
+	}
+
+	return nil
+}
+
+// Filter selects events from the log. Zero values match everything.
+type Filter struct {
+	AfterID uint64    `json:"afterId"`
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+	Node    string    `json:"node"`
+	Types   []Type    `json:"types"`
+	Limit   int       `json:"limit"`
+}
+
+func (f *Filter) match(e Event) bool {
+	switch {
+	case e.ID <= f.AfterID:
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	case f.Node != "" && e.Node != f.Node:
+		return false
+	case len(f.Types) > 0 && !slices.Contains(f.Types, e.Type):
+		return false
+	}
+
+	return true
+}
+
+// Query returns the events matching f, oldest first. When a limit is set
+// only the most recent matching events are returned. Events that have been
+// rotated out of the log are not returned.
+func (l *Log) Query(f Filter) ([]Event, error) {
+	out := []Event{}
+	err := l.scan(func(e Event) {
+		if !f.match(e) {
+			return
+		}
+
+		out = append(out, e)
+		// Keep at most twice the limit in memory while scanning.
+		if f.Limit > 0 && len(out) >= 2*f.Limit {
+			out = append(out[:0], out[len(out)-f.Limit:]...)
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+
+	return out, nil
+}
+
+// scan visits every event in the rotated file and then the current log.
+func (l *Log) scan(visit func(Event)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, path := range []string{l.old, l.path} {
+		if err := scanFile(path, visit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scanFile(path string, visit func(Event)) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		// Skip a torn final line rather than refusing to start.
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+
+		visit(e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read event log: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package events
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogQuery(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.Append(
+		Event{Time: t0, Type: NodeAdded, Node: "1"},
+		Event{Time: t0.Add(time.Hour), Type: NodeOffline, Node: "1"},
+		Event{Time: t0.Add(2 * time.Hour), Type: NodeAdded, Node: "2"},
+		Event{Time: t0.Add(3 * time.Hour), Type: NodeOnline, Node: "1"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint64
+	}{
+		{name: "everything", want: []uint64{1, 2, 3, 4}},
+		{name: "after id", filter: Filter{AfterID: 2}, want: []uint64{3, 4}},
+		{name: "time range", filter: Filter{Since: t0.Add(time.Hour), Until: t0.Add(2 * time.Hour)}, want: []uint64{2, 3}},
+		{name: "node", filter: Filter{Node: "2"}, want: []uint64{3}},
+		{name: "types", filter: Filter{Types: []Type{NodeOffline, NodeOnline}}, want: []uint64{2, 4}},
+		{name: "limit keeps the newest", filter: Filter{Limit: 1}, want: []uint64{4}},
+		{name: "limit with filter", filter: Filter{Node: "1", Limit: 2}, want: []uint64{2, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evs, err := l.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []uint64{}
+			for _, e := range evs {
+				got = append(got, e.ID)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRotate(t *testing.T) {
+	tests := []struct {
+		name    string
+		appends int
+		wantIDs int
+	}{
+		{name: "below the limit", appends: 1, wantIDs: 1},
+		// Each append fills the log, so only the current and the rotated
+		// file remain.
+		{name: "rotated twice", appends: 4, wantIDs: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.maxSize = 1
+
+			for range tt.appends {
+				if err := l.Append(Event{Type: NodeAdded, Node: "1"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			evs, err := l.Query(Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(evs) != tt.wantIDs {
+				t.Errorf("got %d events, want %d", len(evs), tt.wantIDs)
+			}
+
+			// IDs keep increasing across rotation and restarts.
+			reopened, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reopened.lastID != uint64(tt.appends) {
+				t.Errorf("lastID = %d, want %d", reopened.lastID, tt.appends)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, logFile)); err != nil {
+				t.Errorf("current log missing: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/atomicfile"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+const stateFile = "events-state.json"
+
+// nodeState is the subset of a node that the tracker compares between
+// netmaps.
+type nodeState struct {
+	Name          string    `json:"name"`
+	Key           string    `json:"key"`
+	Tags          []string  `json:"tags,omitempty"`
+	OS            string    `json:"os,omitempty"`
+	OSVersion     string    `json:"osVersion,omitempty"`
+	ClientVersion string    `json:"clientVersion,omitempty"`
+	Routes        []string  `json:"routes,omitempty"`
+	Online        *bool     `json:"online,omitempty"`
+	KeyExpiry     time.Time `json:"keyExpiry,omitzero"`
+	ExpiryWarned  bool      `json:"expiryWarned,omitempty"`
+}
+
+// Tracker diffs each netmap against the previous one and appends the
+// resulting events to the log. The last seen state is persisted so that
+// changes made while the agent was stopped are still reported.
+type Tracker struct {
+	log  *Log
+	path string
+	mu   sync.Mutex
+	prev map[tailcfg.StableNodeID]nodeState
+
+	// expiryWarning is how far ahead of key expiry to emit NodeKeyExpiring.
+	expiryWarning time.Duration
+}
+
+// NewTracker loads the previous tailnet state from workDir. A state file
+// that cannot be read is logged and ignored, so the next netmap becomes a
+// fresh baseline instead of keeping the agent from starting.
+func NewTracker(workDir string, log *Log, expiryWarning time.Duration) *Tracker {
+	t := &Tracker{
+		log:           log,
+		path:          filepath.Join(workDir, stateFile),
+		expiryWarning: expiryWarning,
+	}
+
+	if err := t.load(); err != nil {
+		util.GetLogger().Error("Ignoring event state, starting from a fresh baseline: %s", err)
+		t.prev = nil
+	}
+
+	return t
+}
+
+func (t *Tracker) load() error {
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to read event state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &t.prev); err != nil {
+		return fmt.Errorf("failed to parse event state: %w", err)
+	}
+
+	return nil
+}
+
+// Observe diffs nm against the previous netmap. The very first netmap only
+// establishes a baseline so an existing tailnet is not reported as new.
+func (t *Tracker) Observe(nm *netmap.NetworkMap, now time.Time) error {
+	next := make(map[tailcfg.StableNodeID]nodeState, len(nm.Peers)+1)
+	for _, n := range slices.Concat(nm.Peers, []tailcfg.NodeView{nm.SelfNode}) {
+		if n.Valid() {
+			next[n.StableID()] = stateOf(n)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Hostinfo and online state can be briefly missing while a node
+	// reconnects. Keep the last known values instead of reporting them as
+	// changed, and remember which key expiries have already been warned of.
+	for id, n := range next {
+		p, ok := t.prev[id]
+		if !ok {
+			continue
+		}
+
+		if n.OS == "" {
+			n.OS, n.OSVersion, n.ClientVersion, n.Routes = p.OS, p.OSVersion, p.ClientVersion, p.Routes
+		}
+
+		if n.Online == nil {
+			n.Online = p.Online
+		}
+
+		if n.KeyExpiry.Equal(p.KeyExpiry) {
+			n.ExpiryWarned = p.ExpiryWarned
+		}
+
+		next[id] = n
+	}
+
+	var evs []Event
+	if t.prev != nil {
+		evs = diff(t.prev, next, now)
+	}
+
+	evs = append(evs, t.checkExpiry(next, now)...)
+
+	// Always persist the new state, even without events, so that a change
+	// the diff ignores is not reported again after a restart.
+	t.prev = next
+	if err := t.save(); err != nil {
+		return err
+	}
+
+	return t.log.Append(evs...)
+}
+
+func (t *Tracker) save() error {
+	data, err := json.Marshal(t.prev)
+	if err != nil {
+		return fmt.Errorf("failed to encode event state: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(t.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write event state: %w", err)
+	}
+
+	return nil
+}
+
+func stateOf(n tailcfg.NodeView) nodeState {
+	s := nodeState{
+		Name:      strings.TrimSuffix(n.Name(), "."),
+		Key:       n.Key().String(),
+		Tags:      n.Tags().AsSlice(),
+		KeyExpiry: n.KeyExpiry(),
+	}
+
+	if online, ok := n.Online().GetOk(); ok {
+		s.Online = &online
+	}
+
+	slices.Sort(s.Tags)
+	hi := n.Hostinfo()
+	if !hi.Valid() {
+		return s
+	}
+
+	s.OS = hi.OS()
+	s.OSVersion = hi.OSVersion()
+	s.ClientVersion = hi.IPNVersion()
+	for _, p := range hi.RoutableIPs().All() {
+		s.Routes = append(s.Routes, p.String())
+	}
+
+	slices.Sort(s.Routes)
+	return s
+}
+
+// checkExpiry emits NodeKeyExpiring once per key for nodes whose key expires
+// within the warning window, marking them as warned in next.
+func (t *Tracker) checkExpiry(next map[tailcfg.StableNodeID]nodeState, now time.Time) []Event {
+	var evs []Event
+	for id, n := range next {
+		if n.KeyExpiry.IsZero() || n.ExpiryWarned || n.KeyExpiry.Sub(now) > t.expiryWarning {
+			continue
+		}
+
+		n.ExpiryWarned = true
+		next[id] = n
+		evs = append(evs, Event{Time: now, Type: NodeKeyExpiring, Node: string(id), Name: n.Name, New: n.KeyExpiry})
+	}
+
+	return evs
+}
+
+func diff(prev, next map[tailcfg.StableNodeID]nodeState, now time.Time) []Event {
+	var evs []Event
+	add := func(typ Type, id tailcfg.StableNodeID, name string, old, new any) {
+		evs = append(evs, Event{Time: now, Type: typ, Node: string(id), Name: name, Old: old, New: new})
+	}
+
+	for id, n := range next {
+		p, ok := prev[id]
+		if !ok {
+			add(NodeAdded, id, n.Name, nil, n.Key)
+			continue
+		}
+
+		if p.Name != n.Name {
+			add(NodeRenamed, id, n.Name, p.Name, n.Name)
+		}
+
+		if p.Key != n.Key {
+			add(NodeKeyRotated, id, n.Name, p.Key, n.Key)
+		}
+
+		if !slices.Equal(p.Tags, n.Tags) {
+			add(NodeTagsChanged, id, n.Name, p.Tags, n.Tags)
+		}
+
+		// Only a change between two known values counts as an upgrade.
+		if p.OS == n.OS && p.OSVersion != "" && n.OSVersion != "" && p.OSVersion != n.OSVersion {
+			add(NodeOSUpgraded, id, n.Name, p.OSVersion, n.OSVersion)
+		}
+
+		if p.ClientVersion != "" && n.ClientVersion != "" && p.ClientVersion != n.ClientVersion {
+			add(NodeClientUpgraded, id, n.Name, p.ClientVersion, n.ClientVersion)
+		}
+
+		if !slices.Equal(p.Routes, n.Routes) {
+			add(NodeRoutesChanged, id, n.Name, p.Routes, n.Routes)
+		}
+
+		if p.Online != nil && n.Online != nil && *p.Online != *n.Online {
+			if *n.Online {
+				add(NodeOnline, id, n.Name, nil, nil)
+			} else {
+				add(NodeOffline, id, n.Name, nil, nil)
+			}
+		}
+	}
+
+	for id, p := range prev {
+		if _, ok := next[id]; !ok {
+			add(NodeRemoved, id, p.Name, p.Key, nil)
+		}
+	}
+
+	slices.SortStableFunc(evs, func(a, b Event) int {
+		return strings.Compare(a.Node, b.Node)
+	})
+
+	return evs
+}
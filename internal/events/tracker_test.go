@@ -0,0 +1,234 @@
+package events
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/opt"
+)
+
+type testPeer struct {
+	id      string
+	name    string
+	key     key.NodePublic
+	online  opt.Bool
+	version string
+}
+
+func testNetMap(peers ...testPeer) *netmap.NetworkMap {
+	nm := &netmap.NetworkMap{}
+	for _, p := range peers {
+		n := &tailcfg.Node{
+			StableID: tailcfg.StableNodeID(p.id),
+			Name:     p.name + ".ts.net.",
+			Key:      p.key,
+		}
+
+		if v, ok := p.online.Get(); ok {
+			n.Online = &v
+		}
+
+		if p.version != "" {
+			n.Hostinfo = (&tailcfg.Hostinfo{OS: "linux", IPNVersion: p.version}).View()
+		}
+
+		nm.Peers = append(nm.Peers, n.View())
+	}
+
+	return nm
+}
+
+func TestTrackerObserve(t *testing.T) {
+	k1, k2 := key.NewNode().Public(), key.NewNode().Public()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		maps []*netmap.NetworkMap
+		// restart reloads the tracker from disk before the last netmap.
+		restart bool
+		want    []Type
+	}{
+		{
+			name: "first netmap is a baseline",
+			maps: []*netmap.NetworkMap{testNetMap(testPeer{id: "1", name: "a", key: k1})},
+		},
+		{
+			name: "added and removed nodes",
+			maps: []*netmap.NetworkMap{
+				testNetMap(testPeer{id: "1", name: "a", key: k1}),
+				testNetMap(testPeer{id: "2", name: "b", key: k2}),
+			},
+			want: []Type{NodeRemoved, NodeAdded},
+		},
+		{
+			name: "rename and key rotation",
+			maps: []*netmap.NetworkMap{
+				testNetMap(testPeer{id: "1", name: "a", key: k1}),
+				testNetMap(testPeer{id: "1", name: "b", key: k2}),
+			},
+			want: []Type{NodeRenamed, NodeKeyRotated},
+		},
+		{
+			name: "client upgrade",
+			maps: []*netmap.NetworkMap{
+				testNetMap(testPeer{id: "1", name: "a", key: k1, version: "1.86.0"}),
+				testNetMap(testPeer{id: "1", name: "a", key: k1, version: "1.88.0"}),
+			},
+			want: []Type{NodeClientUpgraded},
+		},
+		{
+			name: "missing hostinfo is not a change",
+			maps: []*netmap.NetworkMap{
+				testNetMap(testPeer{id: "1", name: "a", key: k1, version: "1.86.0"}),
+				testNetMap(testPeer{id: "1", name: "a", key: k1}),
+				testNetMap(testPeer{id: "1", name: "a", key: k1, version: "1.86.0"}),
+			},
+		},
+		{
+			name: "state without events is persisted",
+			maps: []*netmap.NetworkMap{
+				testNetMap(testPeer{id: "1", name: "a", key: k1}),
+				testNetMap(testPeer{id: "1", name: "a", key: k1, online: "true"}),
+				testNetMap(testPeer{id: "1", name: "a", key: k1, online: "false"}),
+			},
+			restart: true,
+			want:    []Type{NodeOffline},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			log, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tracker := NewTracker(dir, log, time.Hour)
+
+			for i, nm := range tt.maps {
+				if tt.restart && i == len(tt.maps)-1 {
+					tracker = NewTracker(dir, log, time.Hour)
+				}
+
+				if err := tracker.Observe(nm, t0.Add(time.Duration(i)*time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			evs, err := log.Query(Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []Type
+			for _, e := range evs {
+				got = append(got, e.Type)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerUnreadableState(t *testing.T) {
+	k1 := key.NewNode().Public()
+	tests := []struct {
+		name  string
+		state string
+	}{
+		{name: "empty file", state: ""},
+		{name: "torn write", state: `{"1":{"name":"a","ke`},
+		{name: "wrong shape", state: `[]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, stateFile)
+			if err := os.WriteFile(path, []byte(tt.state), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			log, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The first netmap after a bad state file is a fresh baseline.
+			tracker := NewTracker(dir, log, time.Hour)
+			nm := testNetMap(testPeer{id: "1", name: "a", key: k1})
+			if err := tracker.Observe(nm, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+
+			evs, err := log.Query(Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(evs) != 0 {
+				t.Errorf("got %d events, want none", len(evs))
+			}
+
+			if err := NewTracker(dir, log, time.Hour).load(); err != nil {
+				t.Errorf("state not rewritten: %v", err)
+			}
+		})
+	}
+}
+
+func TestTrackerKeyExpiry(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		expiry time.Time
+		want   int
+	}{
+		{name: "within the warning window", expiry: t0.Add(30 * time.Minute), want: 1},
+		{name: "outside the warning window", expiry: t0.Add(48 * time.Hour)},
+		{name: "no expiry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			log, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tracker := NewTracker(dir, log, time.Hour)
+
+			nm := &netmap.NetworkMap{Peers: []tailcfg.NodeView{
+				(&tailcfg.Node{StableID: "1", Name: "a.", KeyExpiry: tt.expiry}).View(),
+			}}
+
+			// A key is only warned about once, however many netmaps follow.
+			for i := range 3 {
+				if err := tracker.Observe(nm, t0.Add(time.Duration(i)*time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			evs, err := log.Query(Filter{Types: []Type{NodeKeyExpiring}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(evs) != tt.want {
+				t.Errorf("got %d key expiry events, want %d", len(evs), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package history
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Point is a single node's state as recorded during one sync. The short
+// field names keep the on-disk records compact.
+type Point struct {
+	Online    bool    `json:"o"`
+	Path      string  `json:"p,omitempty"`
+	DERP      string  `json:"d,omitempty"`
+	LatencyMs float64 `json:"l,omitempty"`
+}
+
+// record is one line in a segment file, covering every node in a sync.
+type record struct {
+	Time  int64            `json:"t"`
+	Nodes map[string]Point `json:"n"`
+}
+
+// Store is an append-only history of node state kept under the agent work
+// directory. Samples are written to one segment per UTC day; segments from
+// earlier days are gzipped and deleted once they fall out of retention.
+type Store struct {
+	dir       string
+	retention time.Duration
+	mu        sync.Mutex
+	day       string
+}
+
+const (
+	segmentExt = ".jsonl"
+	dayLayout  = "2006-01-02"
+)
+
+// Open prepares the history directory inside workDir.
+func Open(workDir string, retention time.Duration) (*Store, error) {
+	dir := filepath.Join(workDir, "history")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	return &Store{dir: dir, retention: retention}, nil
+}
+
+// Append records the state of every node at time t.
+func (s *Store) Append(t time.Time, nodes map[string]Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day := t.UTC().Format(dayLayout)
+	if day != s.day {
+		if err := s.compact(day, t); err != nil {
+			return err
+		}
+
+		s.day = day
+	}
+
+	data, err := json.Marshal(record{Time: t.Unix(), Nodes: nodes})
+	if err != nil {
+		return fmt.Errorf("failed to encode history record: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, day+segmentExt), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history segment: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write history record: %w", err)
+	}
+
+	return nil
+}
+
+// compact gzips segments from days before today and removes segments that
+// are older than the retention period.
+func (s *Store) compact(today string, now time.Time) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list history segments: %w", err)
+	}
+
+	cutoff := now.Add(-s.retention).UTC().Format(dayLayout)
+	for _, e := range entries {
+		day, compressed := segmentDay(e.Name())
+		if day == "" {
+			continue
+		}
+
+		path := filepath.Join(s.dir, e.Name())
+		if day < cutoff {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove expired segment: %w", err)
+			}
+			continue
+		}
+
+		if !compressed && day != today {
+			if err := gzipFile(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Query returns every sample recorded between from and to, oldest first.
+func (s *Store) Query(from, to time.Time, visit func(t time.Time, nodes map[string]Point)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list history segments: %w", err)
+	}
+
+	first := from.UTC().Format(dayLayout)
+	last := to.UTC().Format(dayLayout)
+
+	var names []string
+	for _, e := range entries {
+		day, _ := segmentDay(e.Name())
+		if day != "" && day >= first && day <= last {
+			names = append(names, e.Name())
+		}
+	}
+
+	slices.Sort(names)
+	for _, name := range names {
+		if err := s.readSegment(name, func(r record) {
+			t := time.Unix(r.Time, 0)
+			if !t.Before(from) && !t.After(to) {
+				visit(t, r.Nodes)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) readSegment(name string, visit func(record)) error {
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return fmt.Errorf("failed to open history segment: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to read history segment %s: %w", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec record
+		// A torn write at the end of a segment only loses that sample.
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+
+		visit(rec)
+	}
+
+	return scanner.Err()
+}
+
+// segmentDay returns the day a segment file covers, or "" if name is not a
+// segment.
+func segmentDay(name string) (day string, compressed bool) {
+	compressed = strings.HasSuffix(name, ".gz")
+	base, ok := strings.CutSuffix(strings.TrimSuffix(name, ".gz"), segmentExt)
+	if !ok {
+		return "", false
+	}
+
+	if _, err := time.Parse(dayLayout, base); err != nil {
+		return "", false
+	}
+
+	return base, compressed
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open segment for compression: %w", err)
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed segment: %w", err)
+	}
+
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compress segment: %w", err)
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return fmt.Errorf("failed to compress segment: %w", err)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,216 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestForNode(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return t0.Add(time.Duration(m) * time.Minute) }
+	end := func(m int) *time.Time { t := at(m); return &t }
+
+	tests := []struct {
+		name        string
+		samples     []map[string]Point
+		node        string
+		wantUptime  float64
+		wantOutages []Outage
+		wantSamples int
+	}{
+		{
+			name: "always online",
+			samples: []map[string]Point{
+				{"n1": {Online: true}},
+				{"n1": {Online: true}},
+			},
+			node:        "n1",
+			wantUptime:  1,
+			wantOutages: []Outage{},
+			wantSamples: 2,
+		},
+		{
+			name: "closed outage",
+			samples: []map[string]Point{
+				{"n1": {Online: true}},
+				{"n1": {Online: false}},
+				{"n1": {Online: false}},
+				{"n1": {Online: true}},
+			},
+			node:        "n1",
+			wantUptime:  0.5,
+			wantOutages: []Outage{{Start: at(1), End: end(3)}},
+			wantSamples: 4,
+		},
+		{
+			name: "ongoing outage",
+			samples: []map[string]Point{
+				{"n1": {Online: true}},
+				{"n1": {Online: false}},
+			},
+			node:        "n1",
+			wantUptime:  0.5,
+			wantOutages: []Outage{{Start: at(1)}},
+			wantSamples: 2,
+		},
+		{
+			name: "samples without the node are skipped",
+			samples: []map[string]Point{
+				{"n1": {Online: true}},
+				{"n2": {Online: false}},
+			},
+			node:        "n1",
+			wantUptime:  1,
+			wantOutages: []Outage{},
+			wantSamples: 1,
+		},
+		{
+			name:        "unknown node",
+			samples:     []map[string]Point{{"n1": {Online: true}}},
+			node:        "n3",
+			wantOutages: []Outage{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open(t.TempDir(), 24*time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, nodes := range tt.samples {
+				if err := s.Append(at(i), nodes); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			h, err := s.ForNode(tt.node, t0, at(len(tt.samples)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if h.Uptime != tt.wantUptime {
+				t.Errorf("Uptime = %v, want %v", h.Uptime, tt.wantUptime)
+			}
+
+			if len(h.Samples) != tt.wantSamples {
+				t.Errorf("got %d samples, want %d", len(h.Samples), tt.wantSamples)
+			}
+
+			if !reflect.DeepEqual(normalize(h.Outages), normalize(tt.wantOutages)) {
+				t.Errorf("Outages = %+v, want %+v", h.Outages, tt.wantOutages)
+			}
+		})
+	}
+}
+
+// normalize drops monotonic clock readings and locations so outages can be
+// compared with reflect.DeepEqual.
+func normalize(outages []Outage) []Outage {
+	out := make([]Outage, len(outages))
+	for i, o := range outages {
+		out[i].Start = o.Start.UTC()
+		if o.End != nil {
+			e := o.End.UTC()
+			out[i].End = &e
+		}
+	}
+
+	return out
+}
+
+func TestCompact(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2026, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name      string
+		retention time.Duration
+		days      []int
+		want      []string
+	}{
+		{
+			name:      "earlier days are compressed",
+			retention: 7 * 24 * time.Hour,
+			days:      []int{1, 2, 3},
+			want:      []string{"2026-01-01.jsonl.gz", "2026-01-02.jsonl.gz", "2026-01-03.jsonl"},
+		},
+		{
+			name:      "expired days are removed",
+			retention: 24 * time.Hour,
+			days:      []int{1, 2, 5},
+			want:      []string{"2026-01-05.jsonl"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			s, err := Open(workDir, tt.retention)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, d := range tt.days {
+				if err := s.Append(day(d), map[string]Point{"n1": {Online: true}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entries, err := os.ReadDir(filepath.Join(workDir, "history"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			slices.Sort(got)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("segments = %v, want %v", got, tt.want)
+			}
+
+			// Compressed segments must still be readable.
+			var n int
+			err = s.Query(day(1).Add(-time.Hour), day(tt.days[len(tt.days)-1]), func(time.Time, map[string]Point) { n++ })
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != len(got) {
+				t.Errorf("Query visited %d samples, want %d", n, len(got))
+			}
+		})
+	}
+}
+
+func TestSegmentDay(t *testing.T) {
+	tests := []struct {
+		name           string
+		file           string
+		wantDay        string
+		wantCompressed bool
+	}{
+		{name: "plain", file: "2026-01-02.jsonl", wantDay: "2026-01-02"},
+		{name: "compressed", file: "2026-01-02.jsonl.gz", wantDay: "2026-01-02", wantCompressed: true},
+		{name: "temporary file", file: "2026-01-02.jsonl.gz.tmp"},
+		{name: "not a date", file: "notes.jsonl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, compressed := segmentDay(tt.file)
+			if day != tt.wantDay || compressed != tt.wantCompressed {
+				t.Errorf("segmentDay(%q) = %q, %v, want %q, %v", tt.file, day, compressed, tt.wantDay, tt.wantCompressed)
+			}
+		})
+	}
+}
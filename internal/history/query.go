@@ -0,0 +1,76 @@
+package history
+
+import (
+	"time"
+)
+
+// Sample is one recorded point for a single node.
+type Sample struct {
+	Time time.Time `json:"time"`
+	Point
+}
+
+// Outage is a period during which a node was recorded as offline. An
+// outage that is still ongoing has no end.
+type Outage struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// NodeHistory summarises a node's recorded state over a time window.
+type NodeHistory struct {
+	Node    string    `json:"node"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Uptime  float64   `json:"uptime"`
+	Outages []Outage  `json:"outages"`
+	Samples []Sample  `json:"samples"`
+}
+
+// ForNode returns the recorded history of a node between from and to.
+// Uptime is the fraction of samples in which the node was online.
+func (s *Store) ForNode(node string, from, to time.Time) (*NodeHistory, error) {
+	h := &NodeHistory{
+		Node:    node,
+		From:    from,
+		To:      to,
+		Outages: []Outage{},
+		Samples: []Sample{},
+	}
+
+	var online int
+	var current *Outage
+	err := s.Query(from, to, func(t time.Time, nodes map[string]Point) {
+		p, ok := nodes[node]
+		if !ok {
+			return
+		}
+
+		h.Samples = append(h.Samples, Sample{Time: t, Point: p})
+		if p.Online {
+			online++
+			if current != nil {
+				end := t
+				current.End = &end
+				h.Outages = append(h.Outages, *current)
+				current = nil
+			}
+		} else if current == nil {
+			current = &Outage{Start: t}
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil {
+		h.Outages = append(h.Outages, *current)
+	}
+
+	if len(h.Samples) > 0 {
+		h.Uptime = float64(online) / float64(len(h.Samples))
+	}
+
+	return h, nil
+}
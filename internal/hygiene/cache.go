@@ -0,0 +1,212 @@
+package hygiene
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/tsnet"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/netmap"
+)
+
+const (
+	cacheFile = "nodes.json"
+
+	// maxKeyHistory bounds how many past keys are kept per node.
+	maxKeyHistory = 16
+)
+
+// Entry is what the agent remembers about a node between syncs. NodeKeys
+// and MachineKeys list every key the node has used, oldest first.
+type Entry struct {
+	Hostname     string    `json:"hostname"`
+	NodeKey      string    `json:"nodeKey"`
+	NodeKeys     []string  `json:"nodeKeys,omitempty"`
+	MachineKey   string    `json:"machineKey,omitempty"`
+	MachineKeys  []string  `json:"machineKeys,omitempty"`
+	BackendLogID string    `json:"backendLogID,omitempty"`
+	FirstSeen    time.Time `json:"firstSeen"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// Cache persists node entries in the agent work directory so that last-seen
+// times survive restarts and nodes that control no longer reports on.
+// Entries are keyed by stable node ID, so they survive key rotation.
+type Cache struct {
+	path  string
+	mu    sync.Mutex
+	nodes map[string]*Entry
+}
+
+// LoadCache reads the node cache from workDir, starting empty if it does
+// not exist yet.
+func LoadCache(workDir string) (*Cache, error) {
+	c := &Cache{
+		path:  filepath.Join(workDir, cacheFile),
+		nodes: make(map[string]*Entry),
+	}
+
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node cache: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &c.nodes); err != nil {
+		return nil, fmt.Errorf("failed to parse node cache: %w", err)
+	}
+
+	// Older caches were keyed by node key and can't be matched up.
+	for id := range c.nodes {
+		if strings.HasPrefix(id, "nodekey:") {
+			delete(c.nodes, id)
+		}
+	}
+
+	return c, nil
+}
+
+// Update records the current state of every node and saves the cache.
+// Nodes missing from nodes, such as those whose WhoIs lookup failed, keep
+// their entries; see Observe for how removed nodes are dropped.
+func (c *Cache) Update(nodes map[string]*tsnet.Node, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, n := range nodes {
+		id := string(n.Node.StableID)
+		if id == "" {
+			continue
+		}
+
+		e, ok := c.nodes[id]
+		if !ok {
+			e = &Entry{FirstSeen: now}
+			c.nodes[id] = e
+		}
+
+		e.Hostname = n.Node.Hostinfo.Hostname()
+		e.BackendLogID = n.Node.Hostinfo.BackendLogID()
+		if !n.Node.Key.IsZero() {
+			e.NodeKey = n.Node.Key.String()
+			e.NodeKeys = appendKey(e.NodeKeys, e.NodeKey)
+		}
+
+		if !n.Node.Machine.IsZero() {
+			e.MachineKey = n.Node.Machine.String()
+			e.MachineKeys = appendKey(e.MachineKeys, e.MachineKey)
+		}
+
+		if n.Status.Online {
+			e.LastSeen = now
+		} else if n.Status.LastSeen.After(e.LastSeen) {
+			e.LastSeen = n.Status.LastSeen
+		}
+	}
+
+	return c.saveLocked()
+}
+
+// Observe drops the entries of nodes that are no longer in the netmap. An
+// empty netmap is ignored so a partial update can't wipe the cache.
+func (c *Cache) Observe(nm *netmap.NetworkMap) {
+	if len(nm.Peers) == 0 {
+		return
+	}
+
+	present := make(map[string]bool, len(nm.Peers)+1)
+	if nm.SelfNode.Valid() {
+		present[string(nm.SelfNode.StableID())] = true
+	}
+
+	for _, p := range nm.Peers {
+		present[string(p.StableID())] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := false
+	for id := range c.nodes {
+		if !present[id] {
+			delete(c.nodes, id)
+			removed = true
+		}
+	}
+
+	if removed {
+		if err := c.saveLocked(); err != nil {
+			util.GetLogger().Error("Failed to update node cache: %s", err)
+		}
+	}
+}
+
+func (c *Cache) saveLocked() error {
+	data, err := json.Marshal(c.nodes)
+	if err != nil {
+		return fmt.Errorf("failed to encode node cache: %w", err)
+	}
+
+	if err := atomicfile.WriteFile(c.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write node cache: %w", err)
+	}
+
+	return nil
+}
+
+// Snapshot returns a copy of the cached entries.
+func (c *Cache) Snapshot() map[string]Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make(map[string]Entry, len(c.nodes))
+	for id, e := range c.nodes {
+		cp := *e
+		cp.NodeKeys = append([]string(nil), e.NodeKeys...)
+		cp.MachineKeys = append([]string(nil), e.MachineKeys...)
+		out[id] = cp
+	}
+
+	return out
+}
+
+// StableID returns the stable ID of the node that uses or has used
+// nodeKey, or "" if no cached node matches.
+func (c *Cache) StableID(nodeKey string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, e := range c.nodes {
+		if e.NodeKey == nodeKey || slices.Contains(e.NodeKeys, nodeKey) {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// appendKey adds key to the history unless it is already the latest, and
+// drops the oldest keys past maxKeyHistory.
+func appendKey(history []string, key string) []string {
+	if len(history) > 0 && history[len(history)-1] == key {
+		return history
+	}
+
+	history = append(history, key)
+	if len(history) > maxKeyHistory {
+		history = history[len(history)-maxKeyHistory:]
+	}
+
+	return history
+}
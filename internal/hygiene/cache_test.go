@@ -0,0 +1,222 @@
+package hygiene
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tale/headplane/internal/tsnet"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+func testNode(id string, nodeKey key.NodePublic, machineKey key.MachinePublic, online bool) *tsnet.Node {
+	return &tsnet.Node{
+		Status: &ipnstate.PeerStatus{Online: online},
+		Node: &tailcfg.Node{
+			StableID: tailcfg.StableNodeID(id),
+			Key:      nodeKey,
+			Machine:  machineKey,
+			Hostinfo: (&tailcfg.Hostinfo{Hostname: "host-" + id}).View(),
+		},
+	}
+}
+
+func TestCacheUpdate(t *testing.T) {
+	nk1, nk2 := key.NewNode().Public(), key.NewNode().Public()
+	mk1, mk2 := key.NewMachine().Public(), key.NewMachine().Public()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		syncs           []map[string]*tsnet.Node
+		wantNodeKeys    []string
+		wantMachineKeys []string
+		wantLastSeen    time.Time
+	}{
+		{
+			name: "node key rotation keeps history",
+			syncs: []map[string]*tsnet.Node{
+				{"a": testNode("1", nk1, mk1, true)},
+				{"b": testNode("1", nk2, mk1, true)},
+			},
+			wantNodeKeys:    []string{nk1.String(), nk2.String()},
+			wantMachineKeys: []string{mk1.String()},
+			wantLastSeen:    t0.Add(time.Hour),
+		},
+		{
+			name: "machine key rotation keeps history",
+			syncs: []map[string]*tsnet.Node{
+				{"a": testNode("1", nk1, mk1, true)},
+				{"a": testNode("1", nk1, mk2, true)},
+				{"a": testNode("1", nk1, mk2, true)},
+			},
+			wantNodeKeys:    []string{nk1.String()},
+			wantMachineKeys: []string{mk1.String(), mk2.String()},
+			wantLastSeen:    t0.Add(2 * time.Hour),
+		},
+		{
+			name: "failed lookup keeps the entry",
+			syncs: []map[string]*tsnet.Node{
+				{"a": testNode("1", nk1, mk1, true)},
+				{},
+			},
+			wantNodeKeys:    []string{nk1.String()},
+			wantMachineKeys: []string{mk1.String()},
+			wantLastSeen:    t0,
+		},
+		{
+			name: "offline node keeps last seen",
+			syncs: []map[string]*tsnet.Node{
+				{"a": testNode("1", nk1, mk1, true)},
+				{"a": testNode("1", nk1, mk1, false)},
+			},
+			wantNodeKeys:    []string{nk1.String()},
+			wantMachineKeys: []string{mk1.String()},
+			wantLastSeen:    t0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c, err := LoadCache(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, nodes := range tt.syncs {
+				if err := c.Update(nodes, t0.Add(time.Duration(i)*time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Reload to check the entry was persisted.
+			c, err = LoadCache(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e, ok := c.Snapshot()["1"]
+			if !ok {
+				t.Fatal("entry for node 1 is missing")
+			}
+
+			if !slices.Equal(e.NodeKeys, tt.wantNodeKeys) {
+				t.Errorf("NodeKeys = %v, want %v", e.NodeKeys, tt.wantNodeKeys)
+			}
+
+			if !slices.Equal(e.MachineKeys, tt.wantMachineKeys) {
+				t.Errorf("MachineKeys = %v, want %v", e.MachineKeys, tt.wantMachineKeys)
+			}
+
+			if !e.FirstSeen.Equal(t0) {
+				t.Errorf("FirstSeen = %s, want %s", e.FirstSeen, t0)
+			}
+
+			if !e.LastSeen.Equal(tt.wantLastSeen) {
+				t.Errorf("LastSeen = %s, want %s", e.LastSeen, tt.wantLastSeen)
+			}
+		})
+	}
+}
+
+func TestCacheObserve(t *testing.T) {
+	nk, mk := key.NewNode().Public(), key.NewMachine().Public()
+	peer := func(id string) tailcfg.NodeView {
+		return (&tailcfg.Node{StableID: tailcfg.StableNodeID(id)}).View()
+	}
+
+	tests := []struct {
+		name  string
+		peers []tailcfg.NodeView
+		want  []string
+	}{
+		{name: "removed node is dropped", peers: []tailcfg.NodeView{peer("1"), peer("3")}, want: []string{"1"}},
+		{name: "empty netmap is ignored", peers: nil, want: []string{"1", "2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadCache(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nodes := map[string]*tsnet.Node{"a": testNode("1", nk, mk, true), "b": testNode("2", nk, mk, true)}
+			if err := c.Update(nodes, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+
+			c.Observe(&netmap.NetworkMap{Peers: tt.peers})
+
+			var got []string
+			for id := range c.Snapshot() {
+				got = append(got, id)
+			}
+
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCacheDropsNodeKeyEntries(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"nodekey:abc": {"hostname": "old"}, "7": {"hostname": "new"}}`
+	if err := os.WriteFile(filepath.Join(dir, cacheFile), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap := c.Snapshot()
+	if _, ok := snap["nodekey:abc"]; ok || len(snap) != 1 {
+		t.Errorf("entries = %v, want only node 7", snap)
+	}
+}
+
+func TestCacheStableID(t *testing.T) {
+	nk1, nk2, nk3 := key.NewNode().Public(), key.NewNode().Public(), key.NewNode().Public()
+	mk := key.NewMachine().Public()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c, err := LoadCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, k := range []key.NodePublic{nk1, nk2} {
+		nodes := map[string]*tsnet.Node{k.String(): testNode("1", k, mk, true)}
+		if err := c.Update(nodes, t0.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		nodeKey string
+		want    string
+	}{
+		{name: "current key", nodeKey: nk2.String(), want: "1"},
+		{name: "rotated key", nodeKey: nk1.String(), want: "1"},
+		{name: "unknown key", nodeKey: nk3.String(), want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.StableID(tt.nodeKey); got != tt.want {
+				t.Errorf("StableID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package hygiene
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// Cluster is a group of nodes that are likely the same machine. Nodes are
+// identified by stable node ID.
+type Cluster struct {
+	Reason string   `json:"reason"`
+	Value  string   `json:"value"`
+	Nodes  []string `json:"nodes"`
+	Keep   string   `json:"keep"`
+}
+
+// StaleNode is a node that has not been seen for longer than the threshold.
+type StaleNode struct {
+	Node     string    `json:"node"`
+	NodeKey  string    `json:"nodeKey"`
+	Hostname string    `json:"hostname"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// Report lists the cleanup candidates found in the node cache.
+type Report struct {
+	Duplicates []Cluster   `json:"duplicates"`
+	Stale      []StaleNode `json:"stale"`
+}
+
+// Analyze clusters likely duplicates and finds nodes not seen within
+// staleAfter. Within a cluster, the most recently seen node is the one
+// suggested to keep.
+func Analyze(entries map[string]Entry, staleAfter time.Duration, now time.Time) *Report {
+	r := &Report{
+		Duplicates: []Cluster{},
+		Stale:      []StaleNode{},
+	}
+
+	r.Duplicates = append(r.Duplicates, cluster(entries, "hostname", func(e Entry) []string { return []string{e.Hostname} })...)
+	r.Duplicates = append(r.Duplicates, cluster(entries, "machineKey", machineKeys)...)
+	r.Duplicates = append(r.Duplicates, cluster(entries, "backendLogID", func(e Entry) []string { return []string{e.BackendLogID} })...)
+
+	for id, e := range entries {
+		// Nodes we have never seen online count from when we first saw them.
+		last := e.LastSeen
+		if last.IsZero() {
+			last = e.FirstSeen
+		}
+
+		if now.Sub(last) > staleAfter {
+			r.Stale = append(r.Stale, StaleNode{Node: id, NodeKey: e.NodeKey, Hostname: e.Hostname, LastSeen: e.LastSeen})
+		}
+	}
+
+	slices.SortFunc(r.Stale, func(a, b StaleNode) int {
+		return a.LastSeen.Compare(b.LastSeen)
+	})
+
+	return r
+}
+
+// machineKeys returns every machine key a node has used, so a machine
+// that re-registered under a new key still clusters with its old nodes.
+func machineKeys(e Entry) []string {
+	if len(e.MachineKeys) == 0 {
+		return []string{e.MachineKey}
+	}
+
+	return e.MachineKeys
+}
+
+// cluster groups entries sharing a non-empty value of field.
+func cluster(entries map[string]Entry, reason string, field func(Entry) []string) []Cluster {
+	groups := make(map[string][]string)
+	for id, e := range entries {
+		for _, v := range field(e) {
+			if v != "" && !slices.Contains(groups[v], id) {
+				groups[v] = append(groups[v], id)
+			}
+		}
+	}
+
+	var out []Cluster
+	for v, ids := range groups {
+		if len(ids) < 2 {
+			continue
+		}
+
+		// Most recently seen first, falling back to the newest registration.
+		slices.SortFunc(ids, func(a, b string) int {
+			ea, eb := entries[a], entries[b]
+			if c := eb.LastSeen.Compare(ea.LastSeen); c != 0 {
+				return c
+			}
+
+			if c := eb.FirstSeen.Compare(ea.FirstSeen); c != 0 {
+				return c
+			}
+
+			return cmp.Compare(a, b)
+		})
+
+		out = append(out, Cluster{Reason: reason, Value: v, Nodes: ids, Keep: ids[0]})
+	}
+
+	slices.SortFunc(out, func(a, b Cluster) int { return cmp.Compare(a.Value, b.Value) })
+	return out
+}
@@ -0,0 +1,71 @@
+package hygiene
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnalyze(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name       string
+		entries    map[string]Entry
+		wantDups   []Cluster
+		wantStale  []StaleNode
+		staleAfter time.Duration
+	}{
+		{
+			name: "hostname duplicates keep the most recent",
+			entries: map[string]Entry{
+				"1": {Hostname: "web", LastSeen: now.Add(-2 * day)},
+				"2": {Hostname: "web", LastSeen: now.Add(-time.Hour)},
+				"3": {Hostname: "db", LastSeen: now},
+			},
+			staleAfter: 30 * day,
+			wantDups:   []Cluster{{Reason: "hostname", Value: "web", Nodes: []string{"2", "1"}, Keep: "2"}},
+			wantStale:  []StaleNode{},
+		},
+		{
+			name: "machine key history clusters re-registered nodes",
+			entries: map[string]Entry{
+				"1": {Hostname: "a", MachineKey: "mkey:1", MachineKeys: []string{"mkey:1"}, LastSeen: now.Add(-day)},
+				"2": {Hostname: "b", MachineKey: "mkey:2", MachineKeys: []string{"mkey:1", "mkey:2"}, LastSeen: now},
+			},
+			staleAfter: 30 * day,
+			wantDups:   []Cluster{{Reason: "machineKey", Value: "mkey:1", Nodes: []string{"2", "1"}, Keep: "2"}},
+			wantStale:  []StaleNode{},
+		},
+		{
+			name: "stale nodes ordered by last seen",
+			entries: map[string]Entry{
+				"1": {Hostname: "a", NodeKey: "nodekey:1", LastSeen: now.Add(-40 * day)},
+				"2": {Hostname: "b", NodeKey: "nodekey:2", LastSeen: now.Add(-60 * day)},
+				"3": {Hostname: "c", FirstSeen: now.Add(-31 * day)},
+				"4": {Hostname: "d", LastSeen: now},
+			},
+			staleAfter: 30 * day,
+			wantDups:   []Cluster{},
+			wantStale: []StaleNode{
+				{Node: "3", Hostname: "c"},
+				{Node: "2", NodeKey: "nodekey:2", Hostname: "b", LastSeen: now.Add(-60 * day)},
+				{Node: "1", NodeKey: "nodekey:1", Hostname: "a", LastSeen: now.Add(-40 * day)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Analyze(tt.entries, tt.staleAfter, now)
+			if !reflect.DeepEqual(r.Duplicates, tt.wantDups) {
+				t.Errorf("Duplicates = %+v, want %+v", r.Duplicates, tt.wantDups)
+			}
+
+			if !reflect.DeepEqual(r.Stale, tt.wantStale) {
+				t.Errorf("Stale = %+v, want %+v", r.Stale, tt.wantStale)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package inventory
+
+import (
+	"slices"
+	"strings"
+)
+
+// AnsibleGroup is a group in Ansible's dynamic inventory JSON format.
+type AnsibleGroup struct {
+	Hosts []string `json:"hosts"`
+}
+
+// AnsibleInventory is the output of a dynamic inventory script's --list.
+// Groups are flattened into the top level next to _meta when encoded.
+type AnsibleInventory map[string]any
+
+// Ansible groups hosts by user, tag, OS and online state. Group names only
+// contain characters Ansible accepts, e.g. tag_server or user_alice_example_com.
+func Ansible(hosts []Host) AnsibleInventory {
+	groups := make(map[string]*AnsibleGroup)
+	add := func(group, host string) {
+		group = groupName(group)
+		if groups[group] == nil {
+			groups[group] = &AnsibleGroup{}
+		}
+
+		groups[group].Hosts = append(groups[group].Hosts, host)
+	}
+
+	hostvars := make(map[string]map[string]any, len(hosts))
+	for _, h := range hosts {
+		if h.User != "" {
+			add("user_"+h.User, h.Name)
+		}
+
+		for _, tag := range h.Tags {
+			add("tag_"+strings.TrimPrefix(tag, "tag:"), h.Name)
+		}
+
+		if h.OS != "" {
+			add("os_"+h.OS, h.Name)
+		}
+
+		if h.Online {
+			add("online", h.Name)
+		} else {
+			add("offline", h.Name)
+		}
+
+		hostvars[h.Name] = AnsibleHostVars(h)
+	}
+
+	inv := AnsibleInventory{"_meta": map[string]any{"hostvars": hostvars}}
+	for name, g := range groups {
+		slices.Sort(g.Hosts)
+		inv[name] = g
+	}
+
+	return inv
+}
+
+// AnsibleHostVars returns the variables Ansible sees for a host.
+func AnsibleHostVars(h Host) map[string]any {
+	ips := make([]string, 0, 2)
+	for _, ip := range h.IPs() {
+		ips = append(ips, ip.String())
+	}
+
+	routes := make([]string, len(h.Routes))
+	for i, r := range h.Routes {
+		routes[i] = r.String()
+	}
+
+	vars := map[string]any{
+		"tailscale_id":             h.ID,
+		"tailscale_name":           h.Name,
+		"tailscale_hostname":       h.Hostname,
+		"tailscale_user":           h.User,
+		"tailscale_tags":           h.Tags,
+		"tailscale_ips":            ips,
+		"tailscale_routes":         routes,
+		"tailscale_exit_node":      h.ExitNode,
+		"tailscale_online":         h.Online,
+		"tailscale_os":             h.OS,
+		"tailscale_os_version":     h.OSVersion,
+		"tailscale_distro":         h.Distro,
+		"tailscale_distro_version": h.DistroVersion,
+		"tailscale_client_version": h.ClientVersion,
+	}
+
+	if len(ips) > 0 {
+		vars["ansible_host"] = ips[0]
+	}
+
+	return vars
+}
+
+// groupName replaces anything but letters, digits and underscores with an
+// underscore and lowercases the result.
+func groupName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,130 @@
+package inventory
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestAnsible(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []Host
+		want  map[string][]string
+	}{
+		{
+			name:  "empty",
+			hosts: nil,
+			want:  map[string][]string{},
+		},
+		{
+			name: "user and os groups",
+			hosts: []Host{
+				{Name: "web.example.ts.net", User: "alice@example.com", OS: "linux", Online: true},
+				{Name: "db.example.ts.net", User: "alice@example.com", OS: "linux"},
+			},
+			want: map[string][]string{
+				"user_alice_example_com": {"db.example.ts.net", "web.example.ts.net"},
+				"os_linux":               {"db.example.ts.net", "web.example.ts.net"},
+				"online":                 {"web.example.ts.net"},
+				"offline":                {"db.example.ts.net"},
+			},
+		},
+		{
+			name: "tags drop the prefix",
+			hosts: []Host{
+				{Name: "ci.example.ts.net", Tags: []string{"tag:server", "tag:CI-Runner"}, Online: true},
+			},
+			want: map[string][]string{
+				"tag_server":    {"ci.example.ts.net"},
+				"tag_ci_runner": {"ci.example.ts.net"},
+				"online":        {"ci.example.ts.net"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv := Ansible(tt.hosts)
+			got := make(map[string][]string)
+			for name, v := range inv {
+				if name == "_meta" {
+					continue
+				}
+
+				got[name] = v.(*AnsibleGroup).Hosts
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups = %v, want %v", got, tt.want)
+			}
+
+			meta := inv["_meta"].(map[string]any)
+			hostvars := meta["hostvars"].(map[string]map[string]any)
+			if len(hostvars) != len(tt.hosts) {
+				t.Errorf("len(hostvars) = %d, want %d", len(hostvars), len(tt.hosts))
+			}
+		})
+	}
+}
+
+func TestAnsibleHostVars(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        Host
+		ansibleHost any
+		ips         []string
+	}{
+		{
+			name:        "dual stack",
+			host:        Host{IPv4: netip.MustParseAddr("100.64.0.1"), IPv6: netip.MustParseAddr("fd7a:115c:a1e0::1")},
+			ansibleHost: "100.64.0.1",
+			ips:         []string{"100.64.0.1", "fd7a:115c:a1e0::1"},
+		},
+		{
+			name:        "ipv6 only",
+			host:        Host{IPv6: netip.MustParseAddr("fd7a:115c:a1e0::1")},
+			ansibleHost: "fd7a:115c:a1e0::1",
+			ips:         []string{"fd7a:115c:a1e0::1"},
+		},
+		{
+			name:        "no addresses",
+			host:        Host{},
+			ansibleHost: nil,
+			ips:         []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := AnsibleHostVars(tt.host)
+			if vars["ansible_host"] != tt.ansibleHost {
+				t.Errorf("ansible_host = %v, want %v", vars["ansible_host"], tt.ansibleHost)
+			}
+
+			if !reflect.DeepEqual(vars["tailscale_ips"], tt.ips) {
+				t.Errorf("tailscale_ips = %v, want %v", vars["tailscale_ips"], tt.ips)
+			}
+		})
+	}
+}
+
+func TestGroupName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"online", "online"},
+		{"tag_Server", "tag_server"},
+		{"user_alice@example.com", "user_alice_example_com"},
+		{"os_windows 11", "os_windows_11"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := groupName(tt.in); got != tt.want {
+				t.Errorf("groupName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns lists every exportable column in their default order.
+var Columns = []string{
+	"id", "name", "hostname", "user", "tags", "os", "osVersion", "distro",
+	"distroVersion", "clientVersion", "ipv4", "ipv6", "routes", "exitNode",
+	"online", "lastSeen",
+}
+
+// Filter selects hosts for an export. Empty fields match every host.
+type Filter struct {
+	Tag    string
+	User   string
+	Online *bool
+}
+
+// Match reports whether h passes the filter.
+func (f Filter) Match(h Host) bool {
+	if f.Tag != "" && !slices.Contains(h.Tags, f.Tag) {
+		return false
+	}
+
+	if f.User != "" && !strings.EqualFold(f.User, h.User) {
+		return false
+	}
+
+	return f.Online == nil || *f.Online == h.Online
+}
+
+// Export writes hosts in format (csv, json or yaml) with the given columns.
+// Online hosts are reported as last seen at the snapshot time.
+func Export(w io.Writer, format string, snap *Snapshot, columns []string) error {
+	for _, c := range columns {
+		if !slices.Contains(Columns, c) {
+			return fmt.Errorf("unknown column %q", c)
+		}
+	}
+
+	rows := make([][]any, len(snap.Hosts))
+	for i, h := range snap.Hosts {
+		if h.Online {
+			h.LastSeen = snap.Time
+		}
+
+		for _, c := range columns {
+			rows[i] = append(rows[i], column(h, c))
+		}
+	}
+
+	switch format {
+	case "csv":
+		return writeCSV(w, columns, rows)
+	case "json":
+		return writeJSON(w, columns, rows)
+	case "yaml":
+		return writeYAML(w, columns, rows)
+	}
+
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func column(h Host, name string) any {
+	switch name {
+	case "id":
+		return h.ID
+	case "name":
+		return h.Name
+	case "hostname":
+		return h.Hostname
+	case "user":
+		return h.User
+	case "tags":
+		return h.Tags
+	case "os":
+		return h.OS
+	case "osVersion":
+		return h.OSVersion
+	case "distro":
+		return h.Distro
+	case "distroVersion":
+		return h.DistroVersion
+	case "clientVersion":
+		return h.ClientVersion
+	case "ipv4", "ipv6":
+		ip := h.IPv4
+		if name == "ipv6" {
+			ip = h.IPv6
+		}
+
+		if !ip.IsValid() {
+			return ""
+		}
+
+		return ip.String()
+	case "routes":
+		routes := make([]string, len(h.Routes))
+		for i, r := range h.Routes {
+			routes[i] = r.String()
+		}
+
+		return routes
+	case "exitNode":
+		return h.ExitNode
+	case "online":
+		return h.Online
+	case "lastSeen":
+		if h.LastSeen.IsZero() {
+			return ""
+		}
+
+		return h.LastSeen.UTC().Format(time.RFC3339)
+	}
+
+	return nil
+}
+
+// writeCSV writes a header row followed by one row per host. Lists are
+// joined with spaces.
+func writeCSV(w io.Writer, columns []string, rows [][]any) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			switch v := v.(type) {
+			case []string:
+				record[i] = strings.Join(v, " ")
+			case bool:
+				record[i] = strconv.FormatBool(v)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSON writes an array of objects, keeping the column order.
+func writeJSON(w io.Writer, columns []string, rows [][]any) error {
+	var b bytes.Buffer
+	b.WriteString("[")
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteString(",")
+		}
+
+		b.WriteString("\n  {")
+		for j, v := range row {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+
+			value, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(&b, "%q: %s", columns[j], value)
+		}
+
+		b.WriteString("}")
+	}
+
+	b.WriteString("\n]\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeYAML writes a sequence of mappings. Strings are double-quoted, which
+// is valid YAML for any value, and lists use flow style.
+func writeYAML(w io.Writer, columns []string, rows [][]any) error {
+	var b bytes.Buffer
+	if len(rows) == 0 {
+		b.WriteString("[]\n")
+	}
+
+	for _, row := range rows {
+		for j, v := range row {
+			prefix := "  "
+			if j == 0 {
+				prefix = "- "
+			}
+
+			fmt.Fprintf(&b, "%s%s: %s\n", prefix, columns[j], yamlValue(v))
+		}
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func yamlValue(v any) string {
+	switch v := v.(type) {
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = strconv.Quote(s)
+		}
+
+		return "[" + strings.Join(quoted, ", ") + "]"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return strconv.Quote(v)
+	}
+
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,98 @@
+package inventory
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterMatch(t *testing.T) {
+	online, offline := true, false
+	h := Host{User: "alice@example.com", Tags: []string{"tag:server"}, Online: true}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty", filter: Filter{}, want: true},
+		{name: "tag", filter: Filter{Tag: "tag:server"}, want: true},
+		{name: "other tag", filter: Filter{Tag: "tag:db"}, want: false},
+		{name: "user ignores case", filter: Filter{User: "Alice@Example.com"}, want: true},
+		{name: "other user", filter: Filter{User: "bob@example.com"}, want: false},
+		{name: "online", filter: Filter{Online: &online}, want: true},
+		{name: "offline", filter: Filter{Online: &offline}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(h); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	snap := &Snapshot{
+		Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Hosts: []Host{{
+			Name:   "web.example.ts.net",
+			Tags:   []string{"tag:a", "tag:b"},
+			IPv4:   netip.MustParseAddr("100.64.0.1"),
+			Online: true,
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		format  string
+		columns []string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "csv",
+			format:  "csv",
+			columns: []string{"name", "tags", "ipv6", "online", "lastSeen"},
+			want:    "name,tags,ipv6,online,lastSeen\nweb.example.ts.net,tag:a tag:b,,true,2026-01-02T03:04:05Z\n",
+		},
+		{
+			name:    "json",
+			format:  "json",
+			columns: []string{"name", "tags", "online"},
+			want:    "[\n  {\"name\": \"web.example.ts.net\", \"tags\": [\"tag:a\",\"tag:b\"], \"online\": true}\n]\n",
+		},
+		{
+			name:    "yaml",
+			format:  "yaml",
+			columns: []string{"name", "tags", "ipv4"},
+			want:    "- name: \"web.example.ts.net\"\n  tags: [\"tag:a\", \"tag:b\"]\n  ipv4: \"100.64.0.1\"\n",
+		},
+		{name: "unknown column", format: "csv", columns: []string{"mac"}, wantErr: `unknown column "mac"`},
+		{name: "unknown format", format: "xml", columns: []string{"name"}, wantErr: `unknown format "xml"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			err := Export(&b, tt.format, snap, tt.columns)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Export error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+
+			if b.String() != tt.want {
+				t.Errorf("Export =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package inventory
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// Host is a flattened view of a tailnet node used by the export formats.
+type Host struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Hostname      string         `json:"hostname"`
+	User          string         `json:"user"`
+	Tags          []string       `json:"tags"`
+	OS            string         `json:"os"`
+	OSVersion     string         `json:"osVersion"`
+	Distro        string         `json:"distro"`
+	DistroVersion string         `json:"distroVersion"`
+	ClientVersion string         `json:"clientVersion"`
+	IPv4          netip.Addr     `json:"ipv4,omitzero"`
+	IPv6          netip.Addr     `json:"ipv6,omitzero"`
+	Routes        []netip.Prefix `json:"routes"`
+	PrimaryRoutes []netip.Prefix `json:"primaryRoutes"`
+	ExitNode      bool           `json:"exitNode"`
+	Online        bool           `json:"online"`
+	LastSeen      time.Time      `json:"lastSeen,omitzero"`
+	SSHHostKeys   []string       `json:"sshHostKeys"`
+}
+
+// ShortName returns the first label of the MagicDNS name.
+func (h Host) ShortName() string {
+	short, _, _ := strings.Cut(h.Name, ".")
+	return short
+}
+
+// IPs returns the host's Tailscale addresses, IPv4 first.
+func (h Host) IPs() []netip.Addr {
+	var ips []netip.Addr
+	for _, ip := range []netip.Addr{h.IPv4, h.IPv6} {
+		if ip.IsValid() {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+// FromNetMap returns every valid peer in nm, ordered by name.
+func FromNetMap(nm *netmap.NetworkMap) []Host {
+	hosts := make([]Host, 0, len(nm.Peers))
+	for _, n := range nm.Peers {
+		if !n.Valid() || !n.Hostinfo().Valid() {
+			continue
+		}
+
+		hosts = append(hosts, fromNode(nm, n))
+	}
+
+	slices.SortFunc(hosts, func(a, b Host) int { return strings.Compare(a.Name, b.Name) })
+	return hosts
+}
+
+// Self returns the node nm belongs to, if it is valid.
+func Self(nm *netmap.NetworkMap) (Host, bool) {
+	n := nm.SelfNode
+	if !n.Valid() || !n.Hostinfo().Valid() {
+		return Host{}, false
+	}
+
+	return fromNode(nm, n), true
+}
+
+func fromNode(nm *netmap.NetworkMap, n tailcfg.NodeView) Host {
+	hi := n.Hostinfo()
+	h := Host{
+		ID:            string(n.StableID()),
+		Name:          strings.TrimSuffix(n.Name(), "."),
+		Hostname:      hi.Hostname(),
+		Tags:          n.Tags().AsSlice(),
+		OS:            hi.OS(),
+		OSVersion:     hi.OSVersion(),
+		Distro:        hi.Distro(),
+		DistroVersion: hi.DistroVersion(),
+		ClientVersion: hi.IPNVersion(),
+		Routes:        []netip.Prefix{},
+		PrimaryRoutes: n.PrimaryRoutes().AsSlice(),
+		Online:        n.Online().Get(),
+		SSHHostKeys:   hi.SSH_HostKeys().AsSlice(),
+	}
+
+	if h.Tags == nil {
+		h.Tags = []string{}
+	}
+
+	if h.PrimaryRoutes == nil {
+		h.PrimaryRoutes = []netip.Prefix{}
+	}
+
+	if h.SSHHostKeys == nil {
+		h.SSHHostKeys = []string{}
+	}
+
+	// Control only reports when offline nodes were last seen.
+	if t, ok := n.LastSeen().GetOk(); ok && !h.Online {
+		h.LastSeen = t
+	}
+
+	if up, ok := nm.UserProfiles[n.User()]; ok {
+		h.User = up.LoginName()
+	}
+
+	for _, p := range n.Addresses().All() {
+		switch {
+		case p.Addr().Is4() && !h.IPv4.IsValid():
+			h.IPv4 = p.Addr()
+		case p.Addr().Is6() && !h.IPv6.IsValid():
+			h.IPv6 = p.Addr()
+		}
+	}
+
+	for _, p := range hi.RoutableIPs().All() {
+		if tsaddr.IsExitRoute(p) {
+			h.ExitNode = true
+		} else {
+			h.Routes = append(h.Routes, p)
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,93 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/netmap"
+)
+
+const snapshotFile = "inventory.json"
+
+// Snapshot is the host list the running agent keeps in its work directory.
+// Every export reads it instead of joining the tailnet itself, since a second
+// node started with the agent's keys would compete with the agent for its
+// session with the control server.
+type Snapshot struct {
+	Time   time.Time `json:"time"`
+	Domain string    `json:"domain"`
+	Hosts  []Host    `json:"hosts"`
+}
+
+// Writer saves a snapshot whenever the hosts in the netmap change.
+type Writer struct {
+	path string
+
+	mu   sync.Mutex
+	last []byte
+}
+
+// NewWriter returns a Writer that saves snapshots into workDir.
+func NewWriter(workDir string) *Writer {
+	return &Writer{path: filepath.Join(workDir, snapshotFile)}
+}
+
+// Observe writes a new snapshot if the hosts or domain in nm differ from
+// the last one written.
+func (w *Writer) Observe(nm *netmap.NetworkMap) {
+	log := util.GetLogger()
+	snap := Snapshot{Domain: nm.MagicDNSSuffix(), Hosts: FromNetMap(nm)}
+
+	key, err := json.Marshal(snap)
+	if err != nil {
+		log.Error("Failed to encode inventory: %s", err)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if bytes.Equal(key, w.last) {
+		return
+	}
+
+	snap.Time = time.Now()
+	data, err := json.Marshal(snap)
+	if err != nil {
+		log.Error("Failed to encode inventory: %s", err)
+		return
+	}
+
+	if err := atomicfile.WriteFile(w.path, data, 0600); err != nil {
+		log.Error("Failed to write inventory: %s", err)
+		return
+	}
+
+	w.last = key
+}
+
+// Load reads the snapshot from workDir.
+func Load(workDir string) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(workDir, snapshotFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no inventory in %s, is the agent running?", workDir)
+		}
+
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+
+	snap := new(Snapshot)
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory: %w", err)
+	}
+
+	return snap, nil
+}
@@ -0,0 +1,166 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// SSHConfig controls how the ssh_config fragment is generated. Users and
+// Ports are keyed by tag; the first of a host's tags with an entry wins.
+type SSHConfig struct {
+	Address     string            `json:"address"`
+	Users       map[string]string `json:"users"`
+	Ports       map[string]int    `json:"ports"`
+	DefaultUser string            `json:"defaultUser"`
+	DefaultPort int               `json:"defaultPort"`
+	JumpHosts   []JumpHost        `json:"jumpHosts"`
+}
+
+// JumpHost is a machine that is not on the tailnet but sits in a subnet
+// advertised by a router. It is reached with ProxyJump through the router.
+type JumpHost struct {
+	Host    string     `json:"host"`
+	Address netip.Addr `json:"address"`
+	User    string     `json:"user"`
+	Port    int        `json:"port"`
+}
+
+// LoadSSHConfig reads an ssh_config export config from a JSON file.
+func LoadSSHConfig(path string) (*SSHConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh_config settings: %w", err)
+	}
+
+	c := new(SSHConfig)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse ssh_config settings: %w", err)
+	}
+
+	if c.Address != "" && c.Address != "ip" && c.Address != "name" {
+		return nil, fmt.Errorf("unknown address type %q", c.Address)
+	}
+
+	return c, nil
+}
+
+// WriteSSHConfig writes one Host block per host followed by the jump hosts.
+// HostKeyAlias is set to the MagicDNS name so keys written by
+// WriteKnownHosts match whichever address ssh connects to.
+func (c *SSHConfig) WriteSSHConfig(w io.Writer, hosts []Host, knownHosts string) error {
+	var b strings.Builder
+	b.WriteString("# Generated by hp_agent. Do not edit.\n")
+
+	for _, h := range hosts {
+		hostName := h.Name
+		if c.Address != "name" {
+			ips := h.IPs()
+			if len(ips) == 0 {
+				continue
+			}
+
+			hostName = ips[0].String()
+		}
+
+		fmt.Fprintf(&b, "\nHost %s %s\n", h.ShortName(), h.Name)
+		fmt.Fprintf(&b, "    HostName %s\n", hostName)
+		if len(h.SSHHostKeys) > 0 {
+			fmt.Fprintf(&b, "    HostKeyAlias %s\n", h.Name)
+			if knownHosts != "" {
+				fmt.Fprintf(&b, "    UserKnownHostsFile %s\n", knownHosts)
+			}
+		}
+
+		writeUserPort(&b, c.user(h), c.port(h))
+	}
+
+	for _, j := range c.JumpHosts {
+		router, ok := routerFor(hosts, j.Address)
+		if !ok {
+			fmt.Fprintf(&b, "\n# %s (%s) is not inside any advertised route\n", j.Host, j.Address)
+			continue
+		}
+
+		fmt.Fprintf(&b, "\nHost %s\n", j.Host)
+		fmt.Fprintf(&b, "    HostName %s\n", j.Address)
+		fmt.Fprintf(&b, "    ProxyJump %s\n", router.Name)
+		writeUserPort(&b, j.User, j.Port)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteKnownHosts writes a known_hosts file with each host's SSH host keys
+// under its MagicDNS name, matching the HostKeyAlias in the ssh_config.
+func WriteKnownHosts(w io.Writer, hosts []Host) error {
+	var b strings.Builder
+	for _, h := range hosts {
+		for _, key := range h.SSHHostKeys {
+			fmt.Fprintf(&b, "%s %s\n", h.Name, key)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeUserPort(b *strings.Builder, user string, port int) {
+	if user != "" {
+		fmt.Fprintf(b, "    User %s\n", user)
+	}
+
+	if port != 0 {
+		fmt.Fprintf(b, "    Port %d\n", port)
+	}
+}
+
+func (c *SSHConfig) user(h Host) string {
+	for _, tag := range h.Tags {
+		if u, ok := c.Users[tag]; ok {
+			return u
+		}
+	}
+
+	return c.DefaultUser
+}
+
+func (c *SSHConfig) port(h Host) int {
+	for _, tag := range h.Tags {
+		if p, ok := c.Ports[tag]; ok {
+			return p
+		}
+	}
+
+	return c.DefaultPort
+}
+
+// routerFor returns the router carrying addr, preferring the primary
+// router for the prefix and then any online router advertising it.
+func routerFor(hosts []Host, addr netip.Addr) (Host, bool) {
+	contains := func(routes []netip.Prefix) bool {
+		return slices.ContainsFunc(routes, func(p netip.Prefix) bool { return p.Contains(addr) })
+	}
+
+	var fallback *Host
+	for i, h := range hosts {
+		if contains(h.PrimaryRoutes) {
+			return h, true
+		}
+
+		if contains(h.Routes) && (fallback == nil || (h.Online && !fallback.Online)) {
+			fallback = &hosts[i]
+		}
+	}
+
+	if fallback == nil {
+		return Host{}, false
+	}
+
+	return *fallback, true
+}
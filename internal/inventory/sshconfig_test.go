@@ -0,0 +1,199 @@
+package inventory
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSSHConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "empty", data: `{}`},
+		{name: "ip addresses", data: `{"address": "ip", "defaultUser": "root"}`},
+		{name: "name addresses", data: `{"address": "name", "ports": {"tag:server": 2222}}`},
+		{name: "unknown address", data: `{"address": "fqdn"}`, wantErr: `unknown address type "fqdn"`},
+		{name: "invalid json", data: `{`, wantErr: "failed to parse ssh_config settings"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ssh.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadSSHConfig(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("LoadSSHConfig: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadSSHConfig error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteSSHConfig(t *testing.T) {
+	web := Host{
+		Name:        "web.example.ts.net",
+		Tags:        []string{"tag:server"},
+		IPv4:        netip.MustParseAddr("100.64.0.1"),
+		SSHHostKeys: []string{"ssh-ed25519 AAAA"},
+	}
+	router := Host{
+		Name:          "router.example.ts.net",
+		IPv4:          netip.MustParseAddr("100.64.0.2"),
+		Routes:        []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+		PrimaryRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+	}
+	noAddr := Host{Name: "gone.example.ts.net"}
+
+	tests := []struct {
+		name       string
+		config     SSHConfig
+		hosts      []Host
+		knownHosts string
+		want       []string
+		notWant    []string
+	}{
+		{
+			name:       "ip address with host key",
+			hosts:      []Host{web},
+			knownHosts: "/etc/ssh/tailnet_known_hosts",
+			want: []string{
+				"Host web web.example.ts.net\n    HostName 100.64.0.1\n",
+				"    HostKeyAlias web.example.ts.net\n",
+				"    UserKnownHostsFile /etc/ssh/tailnet_known_hosts\n",
+			},
+		},
+		{
+			name:   "name address",
+			config: SSHConfig{Address: "name"},
+			hosts:  []Host{web},
+			want:   []string{"    HostName web.example.ts.net\n"},
+		},
+		{
+			name:    "host without addresses is skipped",
+			hosts:   []Host{noAddr},
+			notWant: []string{"gone"},
+		},
+		{
+			name: "tag user and port override the defaults",
+			config: SSHConfig{
+				Users:       map[string]string{"tag:server": "deploy"},
+				Ports:       map[string]int{"tag:server": 2222},
+				DefaultUser: "root",
+				DefaultPort: 22,
+			},
+			hosts: []Host{web, router},
+			want: []string{
+				"HostName 100.64.0.1\n    HostKeyAlias web.example.ts.net\n    User deploy\n    Port 2222\n",
+				"HostName 100.64.0.2\n    User root\n    Port 22\n",
+			},
+		},
+		{
+			name: "jump host through the router",
+			config: SSHConfig{JumpHosts: []JumpHost{
+				{Host: "nas", Address: netip.MustParseAddr("10.0.0.5"), User: "admin"},
+				{Host: "printer", Address: netip.MustParseAddr("192.168.1.5")},
+			}},
+			hosts: []Host{web, router},
+			want: []string{
+				"Host nas\n    HostName 10.0.0.5\n    ProxyJump router.example.ts.net\n    User admin\n",
+				"# printer (192.168.1.5) is not inside any advertised route\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := tt.config.WriteSSHConfig(&b, tt.hosts, tt.knownHosts); err != nil {
+				t.Fatalf("WriteSSHConfig: %v", err)
+			}
+
+			for _, s := range tt.want {
+				if !strings.Contains(b.String(), s) {
+					t.Errorf("output is missing %q:\n%s", s, b.String())
+				}
+			}
+
+			for _, s := range tt.notWant {
+				if strings.Contains(b.String(), s) {
+					t.Errorf("output contains %q:\n%s", s, b.String())
+				}
+			}
+		})
+	}
+}
+
+func TestWriteKnownHosts(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []Host
+		want  string
+	}{
+		{name: "no keys", hosts: []Host{{Name: "web.example.ts.net"}}, want: ""},
+		{
+			name: "every key under the name",
+			hosts: []Host{{
+				Name:        "web.example.ts.net",
+				SSHHostKeys: []string{"ssh-ed25519 AAAA", "ecdsa-sha2-nistp256 BBBB"},
+			}},
+			want: "web.example.ts.net ssh-ed25519 AAAA\nweb.example.ts.net ecdsa-sha2-nistp256 BBBB\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := WriteKnownHosts(&b, tt.hosts); err != nil {
+				t.Fatalf("WriteKnownHosts: %v", err)
+			}
+
+			if b.String() != tt.want {
+				t.Errorf("WriteKnownHosts = %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRouterFor(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+	primary := Host{Name: "primary", PrimaryRoutes: []netip.Prefix{prefix}, Routes: []netip.Prefix{prefix}}
+	offline := Host{Name: "offline", Routes: []netip.Prefix{prefix}}
+	online := Host{Name: "online", Routes: []netip.Prefix{prefix}, Online: true}
+
+	tests := []struct {
+		name   string
+		hosts  []Host
+		addr   string
+		want   string
+		wantOk bool
+	}{
+		{name: "primary wins", hosts: []Host{offline, online, primary}, addr: "10.0.0.1", want: "primary", wantOk: true},
+		{name: "online standby", hosts: []Host{offline, online}, addr: "10.0.0.1", want: "online", wantOk: true},
+		{name: "offline standby", hosts: []Host{offline}, addr: "10.0.0.1", want: "offline", wantOk: true},
+		{name: "outside every route", hosts: []Host{primary}, addr: "10.0.1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := routerFor(tt.hosts, netip.MustParseAddr(tt.addr))
+			if ok != tt.wantOk || got.Name != tt.want {
+				t.Errorf("routerFor = %q, %v, want %q, %v", got.Name, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
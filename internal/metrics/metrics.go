@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/metrics"
+	"tailscale.com/tsweb/varz"
+)
+
+// Agent metrics, exported with the hp_agent_ prefix. Commands update these
+// directly; the variable names follow the tsweb/varz type prefix convention.
+var (
+	Syncs        = &metrics.LabelMap{Label: "result"}
+	SyncDuration = metrics.NewHistogram([]float64{0.5, 1, 2.5, 5, 10, 30, 60})
+	WhoIs        = &metrics.LabelMap{Label: "result"}
+	Peers        = &metrics.LabelMap{Label: "state"}
+	BackendState = &metrics.LabelMap{Label: "state"}
+	PostureNodes = &metrics.LabelMap{Label: "result"}
+	DNSNames     = &metrics.LabelMap{Label: "status"}
+	DNSUpstreams = &metrics.LabelMap{Label: "status"}
+	Duplicates   = new(expvar.Int)
+	StaleNodes   = new(expvar.Int)
+
+	ProbeSuccess  = &metrics.MultiLabelMap[ProbeLabels]{Type: "gauge", Help: "Whether the last probe run succeeded"}
+	ProbeDuration = &metrics.MultiLabelMap[ProbeLabels]{Type: "gauge", Help: "Duration of the last probe run in seconds"}
+	CertNotAfter  = &metrics.MultiLabelMap[CertLabels]{Type: "gauge", Help: "Expiry of the leaf certificate as a Unix timestamp"}
+	SubnetRouteUp = &metrics.MultiLabelMap[SubnetLabels]{Type: "gauge", Help: "Whether the subnet target answered through its primary router"}
+
+	lastNetMap atomic.Int64
+)
+
+// ProbeLabels identifies a synthetic probe against a single node.
+type ProbeLabels struct {
+	Probe string
+	Node  string
+}
+
+// CertLabels identifies a monitored TLS endpoint.
+type CertLabels struct {
+	Target string
+}
+
+// SubnetLabels identifies a subnet route through a router.
+type SubnetLabels struct {
+	Prefix string
+	Router string
+}
+
+var (
+	set = new(metrics.Set)
+	mux = http.NewServeMux()
+)
+
+func init() {
+	set.Set("counter_syncs_total", Syncs)
+	set.Set("histogram_sync_duration_seconds", SyncDuration)
+	set.Set("counter_whois_total", WhoIs)
+	set.Set("gauge_peers", Peers)
+	set.Set("gauge_backend_state", BackendState)
+	set.Set("gauge_netmap_age_seconds", expvar.Func(netMapAge))
+	set.Set("gauge_posture_nodes", PostureNodes)
+	set.Set("gauge_dns_names", DNSNames)
+	set.Set("gauge_dns_upstreams", DNSUpstreams)
+	set.Set("gauge_duplicate_clusters", Duplicates)
+	set.Set("gauge_stale_nodes", StaleNodes)
+	set.Set("gauge_probe_success", ProbeSuccess)
+	set.Set("gauge_probe_duration_seconds", ProbeDuration)
+	set.Set("gauge_cert_not_after_timestamp_seconds", CertNotAfter)
+	set.Set("gauge_subnet_route_up", SubnetRouteUp)
+}
+
+// ObserveNetMap records that a new netmap was received.
+func ObserveNetMap(t time.Time) {
+	lastNetMap.Store(t.Unix())
+}
+
+// SetBackendState marks state as the current backend state.
+func SetBackendState(state string) {
+	BackendState.Init()
+	BackendState.SetInt64(state, 1)
+}
+
+func netMapAge() any {
+	last := lastNetMap.Load()
+	if last == 0 {
+		return float64(-1)
+	}
+
+	return time.Since(time.Unix(last, 0)).Seconds()
+}
+
+// Handler serves the agent metrics followed by the tsnet user metrics.
+func Handler(userMetrics http.Handler) http.Handler {
+	agent := varz.ExpvarDoHandler(func(f func(expvar.KeyValue)) {
+		f(expvar.KeyValue{Key: "hp_agent", Value: set})
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent(w, r)
+		if userMetrics != nil {
+			userMetrics.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Handle registers an extra handler on the metrics listener. It must be
+// called before Serve.
+func Handle(pattern string, h http.Handler) {
+	mux.Handle(pattern, h)
+}
+
+// Serve starts the metrics listener in the background. The address is
+// either host:port or unix:/path/to/socket.
+func Serve(addr string, userMetrics http.Handler) error {
+	log := util.GetLogger()
+
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale metrics socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux.Handle("/metrics", Handler(userMetrics))
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Error("Metrics server stopped: %s", err)
+		}
+	}()
+
+	log.Info("Serving metrics on %s://%s/metrics", network, addr)
+	return nil
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	Syncs.Add("ok", 2)
+	ProbeSuccess.SetInt(ProbeLabels{Probe: "web", Node: "host"}, 1)
+	SetBackendState("Running")
+	ObserveNetMap(time.Now())
+
+	user := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "tailscaled_user_metric 1\n")
+	})
+
+	tests := []struct {
+		name        string
+		userMetrics http.Handler
+		want        []string
+	}{
+		{
+			name: "agent metrics",
+			want: []string{
+				`hp_agent_syncs_total{result="ok"} 2`,
+				`hp_agent_probe_success{probe="web",node="host"} 1`,
+				`hp_agent_backend_state{state="Running"} 1`,
+				"hp_agent_netmap_age_seconds 0",
+			},
+		},
+		{
+			name:        "user metrics appended",
+			userMetrics: user,
+			want:        []string{`hp_agent_syncs_total{result="ok"} 2`, "tailscaled_user_metric 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Handler(tt.userMetrics).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+			body := rec.Body.String()
+			for _, w := range tt.want {
+				if !strings.Contains(body, w) {
+					t.Errorf("metrics output is missing %q:\n%s", w, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Client is an application allowed to sign users in.
+type Client struct {
+	ID           string   `json:"id"`
+	Secret       string   `json:"secret"`
+	RedirectURIs []string `json:"redirectURIs"`
+}
+
+// Config describes the provider. Issuer is the URL clients reach it at and
+// must match the listener; TLSCert and TLSKey enable HTTPS.
+type Config struct {
+	Issuer  string   `json:"issuer"`
+	Listen  string   `json:"listen"`
+	TLSCert string   `json:"tlsCert"`
+	TLSKey  string   `json:"tlsKey"`
+	Clients []Client `json:"clients"`
+}
+
+// Load reads and validates the provider config from a JSON file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OIDC config: %w", err)
+	}
+
+	c := new(Config)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse OIDC config: %w", err)
+	}
+
+	u, err := url.Parse(c.Issuer)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OIDC issuer %q", c.Issuer)
+	}
+	c.Issuer = strings.TrimSuffix(c.Issuer, "/")
+
+	if c.Listen == "" {
+		return nil, fmt.Errorf("OIDC listen address is required")
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return nil, fmt.Errorf("both tlsCert and tlsKey are required for HTTPS")
+	}
+
+	seen := make(map[string]bool)
+	for _, cl := range c.Clients {
+		if cl.ID == "" || cl.Secret == "" {
+			return nil, fmt.Errorf("OIDC clients need an id and secret")
+		}
+
+		if seen[cl.ID] {
+			return nil, fmt.Errorf("duplicate OIDC client %q", cl.ID)
+		}
+		seen[cl.ID] = true
+
+		if len(cl.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("OIDC client %q has no redirect URIs", cl.ID)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Config) client(id string) (*Client, bool) {
+	for i := range c.Clients {
+		if c.Clients[i].ID == id {
+			return &c.Clients[i], true
+		}
+	}
+
+	return nil, false
+}
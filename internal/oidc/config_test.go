@@ -0,0 +1,57 @@
+package oidc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	const client = `{"id": "grafana", "secret": "s", "redirectURIs": ["https://grafana.example.com/login"]}`
+
+	tests := []struct {
+		name       string
+		data       string
+		wantIssuer string
+		wantErr    string
+	}{
+		{
+			name:       "valid",
+			data:       `{"issuer": "https://idp.example.ts.net/", "listen": ":443", "clients": [` + client + `]}`,
+			wantIssuer: "https://idp.example.ts.net",
+		},
+		{name: "bad issuer", data: `{"issuer": "idp", "listen": ":80"}`, wantErr: `invalid OIDC issuer "idp"`},
+		{name: "no listen", data: `{"issuer": "http://idp"}`, wantErr: "listen address is required"},
+		{name: "half a certificate", data: `{"issuer": "http://idp", "listen": ":443", "tlsCert": "c.pem"}`, wantErr: "both tlsCert and tlsKey"},
+		{name: "client without secret", data: `{"issuer": "http://idp", "listen": ":80", "clients": [{"id": "a"}]}`, wantErr: "need an id and secret"},
+		{name: "duplicate client", data: `{"issuer": "http://idp", "listen": ":80", "clients": [` + client + `, ` + client + `]}`, wantErr: `duplicate OIDC client "grafana"`},
+		{name: "no redirect", data: `{"issuer": "http://idp", "listen": ":80", "clients": [{"id": "a", "secret": "s"}]}`, wantErr: `"a" has no redirect URIs`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "oidc.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			if c.Issuer != tt.wantIssuer {
+				t.Errorf("Issuer = %q, want %q", c.Issuer, tt.wantIssuer)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"tailscale.com/atomicfile"
+)
+
+const keyFile = "oidc-key.pem"
+
+// signer holds the RSA key ID tokens are signed with.
+type signer struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+// loadSigner reads the signing key from workDir, generating and saving a
+// new one on first use so tokens stay valid across restarts.
+func loadSigner(workDir string) (*signer, error) {
+	path := filepath.Join(workDir, keyFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateSigner(path)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OIDC key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode OIDC key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OIDC key: %w", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("OIDC key is not an RSA key")
+	}
+
+	return newSigner(key)
+}
+
+func generateSigner(path string) (*signer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate OIDC key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := atomicfile.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save OIDC key: %w", err)
+	}
+
+	return newSigner(key)
+}
+
+// newSigner derives the key ID from a hash of the public key.
+func newSigner(key *rsa.PrivateKey) (*signer, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(der)
+	return &signer{key: key, kid: b64(sum[:8])}, nil
+}
+
+// sign returns claims as a compact RS256 JWT.
+func (s *signer) sign(claims any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.kid})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return input + "." + b64(sig), nil
+}
+
+// jwks returns the public key as a JSON Web Key Set.
+func (s *signer) jwks() map[string]any {
+	pub := s.key.PublicKey
+	return map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": s.kid,
+			"n":   b64(pub.N.Bytes()),
+			"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,367 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/tsnet"
+	"github.com/tale/headplane/internal/util"
+)
+
+const (
+	codeTTL  = 5 * time.Minute
+	tokenTTL = time.Hour
+)
+
+// Identifier looks up the tailnet identity behind a remote address.
+// *tsnet.TSAgent satisfies it.
+type Identifier interface {
+	Identify(ctx context.Context, addr string) (*tsnet.Identity, error)
+}
+
+// grant is an authorization code or access token and what it was issued
+// for.
+type grant struct {
+	client    string
+	redirect  string
+	nonce     string
+	challenge string
+	identity  *tsnet.Identity
+	expires   time.Time
+}
+
+// Provider is an OpenID Connect provider that signs users in with the
+// tailnet identity of the connection, so there is no login page.
+type Provider struct {
+	cfg    *Config
+	who    Identifier
+	signer *signer
+
+	mu     sync.Mutex
+	codes  map[string]*grant
+	tokens map[string]*grant
+}
+
+// New returns a provider with the signing key from workDir.
+func New(cfg *Config, who Identifier, workDir string) (*Provider, error) {
+	s, err := loadSigner(workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		cfg:    cfg,
+		who:    who,
+		signer: s,
+		codes:  make(map[string]*grant),
+		tokens: make(map[string]*grant),
+	}, nil
+}
+
+// Handler returns the provider's HTTP endpoints.
+func (p *Provider) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.serveJWKS)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("/userinfo", p.userinfo)
+	return mux
+}
+
+// Serve answers on l until it fails, over HTTPS when the config has a
+// certificate.
+func (p *Provider) Serve(l net.Listener) error {
+	srv := &http.Server{Handler: p.Handler()}
+	if p.cfg.TLSCert != "" {
+		return srv.ServeTLS(l, p.cfg.TLSCert, p.cfg.TLSKey)
+	}
+
+	return srv.Serve(l)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.cfg.Issuer,
+		"authorization_endpoint":                p.cfg.Issuer + "/authorize",
+		"token_endpoint":                        p.cfg.Issuer + "/token",
+		"userinfo_endpoint":                     p.cfg.Issuer + "/userinfo",
+		"jwks_uri":                              p.cfg.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+		"claims_supported": []string{
+			"sub", "name", "email", "preferred_username", "picture", "tailscale_node",
+		},
+	})
+}
+
+func (p *Provider) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.signer.jwks())
+}
+
+// authorize identifies the caller by their tailnet address and redirects
+// straight back to the client with a code. Errors that can't be trusted to
+// the redirect URI are shown to the user instead.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	client, ok := p.cfg.client(q.Get("client_id"))
+	if !ok {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+
+	redirect := q.Get("redirect_uri")
+	if !slices.Contains(client.RedirectURIs, redirect) {
+		http.Error(w, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return
+	}
+
+	target, err := url.Parse(redirect)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	params := target.Query()
+	if state := q.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+
+	fail := func(code, desc string) {
+		params.Set("error", code)
+		params.Set("error_description", desc)
+		target.RawQuery = params.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+	}
+
+	if q.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the code flow is supported")
+		return
+	}
+
+	if !slices.Contains(strings.Fields(q.Get("scope")), "openid") {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+
+	challenge := q.Get("code_challenge")
+	switch q.Get("code_challenge_method") {
+	case "", "plain":
+	case "S256":
+		challenge = "S256:" + challenge
+	default:
+		fail("invalid_request", "unsupported code_challenge_method")
+		return
+	}
+
+	id, err := p.who.Identify(r.Context(), r.RemoteAddr)
+	if err != nil {
+		util.GetLogger().Debug("OIDC caller %s could not be identified: %s", r.RemoteAddr, err)
+		fail("access_denied", "this provider only works over the tailnet")
+		return
+	}
+
+	if id.Login == "" {
+		fail("access_denied", "tagged nodes cannot sign in")
+		return
+	}
+
+	code := randomToken()
+	p.mu.Lock()
+	p.expireLocked()
+	p.codes[code] = &grant{
+		client:    client.ID,
+		redirect:  redirect,
+		nonce:     q.Get("nonce"),
+		challenge: challenge,
+		identity:  id,
+		expires:   time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	params.Set("code", code)
+	target.RawQuery = params.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// token exchanges an authorization code for an ID token and access token.
+// Codes can only be used once.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", "malformed form")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	client, ok := p.cfg.client(clientID)
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="hp_agent"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	p.expireLocked()
+	g, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !ok || g.client != client.ID || g.redirect != r.PostForm.Get("redirect_uri") {
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	}
+
+	if !verifyChallenge(g.challenge, r.PostForm.Get("code_verifier")) {
+		tokenError(w, "invalid_grant", "code_verifier does not match")
+		return
+	}
+
+	now := time.Now()
+	claims := p.claims(g.identity)
+	claims["iss"] = p.cfg.Issuer
+	claims["aud"] = client.ID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(tokenTTL).Unix()
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+
+	idToken, err := p.signer.sign(claims)
+	if err != nil {
+		util.GetLogger().Error("Failed to sign ID token: %s", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	access := randomToken()
+	p.mu.Lock()
+	p.tokens[access] = &grant{client: client.ID, identity: g.identity, expires: now.Add(tokenTTL)}
+	p.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": access,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	access, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	p.mu.Lock()
+	p.expireLocked()
+	g, ok := p.tokens[access]
+	p.mu.Unlock()
+
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p.claims(g.identity))
+}
+
+// claims returns the user claims for an identity. The login is the
+// subject, since it is stable across the user's nodes.
+func (p *Provider) claims(id *tsnet.Identity) map[string]any {
+	username, _, _ := strings.Cut(id.Login, "@")
+	claims := map[string]any{
+		"sub":                id.Login,
+		"name":               id.DisplayName,
+		"preferred_username": username,
+		"tailscale_node":     id.Node,
+	}
+
+	if strings.Contains(id.Login, "@") {
+		claims["email"] = id.Login
+	}
+
+	if id.ProfilePicURL != "" {
+		claims["picture"] = id.ProfilePicURL
+	}
+
+	return claims
+}
+
+func (p *Provider) expireLocked() {
+	now := time.Now()
+	for k, g := range p.codes {
+		if now.After(g.expires) {
+			delete(p.codes, k)
+		}
+	}
+
+	for k, g := range p.tokens {
+		if now.After(g.expires) {
+			delete(p.tokens, k)
+		}
+	}
+}
+
+// verifyChallenge checks a PKCE verifier. Without a challenge any
+// verifier is accepted.
+func verifyChallenge(challenge, verifier string) bool {
+	if challenge == "" {
+		return true
+	}
+
+	if s256, ok := strings.CutPrefix(challenge, "S256:"); ok {
+		sum := sha256.Sum256([]byte(verifier))
+		return subtle.ConstantTimeCompare([]byte(b64(sum[:])), []byte(s256)) == 1
+	}
+
+	return subtle.ConstantTimeCompare([]byte(verifier), []byte(challenge)) == 1
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func tokenError(w http.ResponseWriter, code, desc string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": desc})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
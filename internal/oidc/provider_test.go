@@ -0,0 +1,248 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tale/headplane/internal/tsnet"
+)
+
+// fakeIdentifier answers every lookup with id, or an error if it is nil.
+type fakeIdentifier struct{ id *tsnet.Identity }
+
+func (f fakeIdentifier) Identify(context.Context, string) (*tsnet.Identity, error) {
+	if f.id == nil {
+		return nil, errors.New("not on the tailnet")
+	}
+
+	return f.id, nil
+}
+
+var alice = &tsnet.Identity{
+	Login:       "alice@example.com",
+	DisplayName: "Alice",
+	Node:        "laptop.example.ts.net",
+	Tags:        []string{},
+}
+
+func testProvider(t *testing.T, id *tsnet.Identity) *Provider {
+	t.Helper()
+	cfg := &Config{
+		Issuer: "https://idp.example.ts.net",
+		Clients: []Client{{
+			ID:           "grafana",
+			Secret:       "secret",
+			RedirectURIs: []string{"https://grafana.example.com/login"},
+		}},
+	}
+
+	p, err := New(cfg, fakeIdentifier{id}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestClaims(t *testing.T) {
+	tests := []struct {
+		name string
+		id   *tsnet.Identity
+		want map[string]any
+	}{
+		{
+			name: "email login",
+			id:   &tsnet.Identity{Login: "alice@example.com", DisplayName: "Alice", ProfilePicURL: "https://example.com/a.png", Node: "laptop"},
+			want: map[string]any{
+				"sub":                "alice@example.com",
+				"name":               "Alice",
+				"preferred_username": "alice",
+				"tailscale_node":     "laptop",
+				"email":              "alice@example.com",
+				"picture":            "https://example.com/a.png",
+			},
+		},
+		{
+			name: "plain login",
+			id:   &tsnet.Identity{Login: "bob", Node: "desktop"},
+			want: map[string]any{
+				"sub":                "bob",
+				"name":               "",
+				"preferred_username": "bob",
+				"tailscale_node":     "desktop",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := (&Provider{}).claims(tt.id)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("claims = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name      string
+		id        *tsnet.Identity
+		query     string
+		wantCode  int
+		wantError string
+	}{
+		{name: "code", id: alice, query: "client_id=grafana&redirect_uri=https://grafana.example.com/login&response_type=code&scope=openid&state=xyz", wantCode: http.StatusFound},
+		{name: "unknown client", id: alice, query: "client_id=other&redirect_uri=https://grafana.example.com/login", wantCode: http.StatusBadRequest},
+		{name: "unregistered redirect", id: alice, query: "client_id=grafana&redirect_uri=https://evil.example.com/", wantCode: http.StatusBadRequest},
+		{name: "implicit flow", id: alice, query: "client_id=grafana&redirect_uri=https://grafana.example.com/login&response_type=token&scope=openid", wantCode: http.StatusFound, wantError: "unsupported_response_type"},
+		{name: "no openid scope", id: alice, query: "client_id=grafana&redirect_uri=https://grafana.example.com/login&response_type=code&scope=email", wantCode: http.StatusFound, wantError: "invalid_scope"},
+		{name: "not on the tailnet", query: "client_id=grafana&redirect_uri=https://grafana.example.com/login&response_type=code&scope=openid", wantCode: http.StatusFound, wantError: "access_denied"},
+		{name: "tagged node", id: &tsnet.Identity{Node: "ci", Tags: []string{"tag:ci"}}, query: "client_id=grafana&redirect_uri=https://grafana.example.com/login&response_type=code&scope=openid", wantCode: http.StatusFound, wantError: "access_denied"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			testProvider(t, tt.id).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/authorize?"+tt.query, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+
+			if rec.Code != http.StatusFound {
+				return
+			}
+
+			loc, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q := loc.Query()
+			if q.Get("error") != tt.wantError {
+				t.Errorf("error = %q, want %q", q.Get("error"), tt.wantError)
+			}
+
+			if tt.wantError == "" && q.Get("code") == "" {
+				t.Error("redirect has no code")
+			}
+		})
+	}
+}
+
+func TestTokenFlow(t *testing.T) {
+	verifier := "a-long-random-verifier"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		verifier string
+		secret   string
+		wantCode int
+	}{
+		{name: "valid", verifier: verifier, secret: "secret", wantCode: http.StatusOK},
+		{name: "wrong verifier", verifier: "other", secret: "secret", wantCode: http.StatusBadRequest},
+		{name: "wrong secret", verifier: verifier, secret: "wrong", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testProvider(t, alice).Handler()
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/authorize?client_id=grafana&redirect_uri=https://grafana.example.com/login"+
+				"&response_type=code&scope=openid&code_challenge_method=S256&code_challenge="+challenge, nil))
+			loc, err := url.Parse(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			form := url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {loc.Query().Get("code")},
+				"redirect_uri":  {"https://grafana.example.com/login"},
+				"client_id":     {"grafana"},
+				"client_secret": {tt.secret},
+				"code_verifier": {tt.verifier},
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("token status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var tok struct {
+				AccessToken string `json:"access_token"`
+				IDToken     string `json:"id_token"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&tok); err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Count(tok.IDToken, ".") != 2 {
+				t.Errorf("id_token = %q is not a JWT", tok.IDToken)
+			}
+
+			req = httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+			req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			var info map[string]any
+			if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+				t.Fatal(err)
+			}
+
+			if info["sub"] != alice.Login {
+				t.Errorf("userinfo sub = %v, want %s", info["sub"], alice.Login)
+			}
+
+			if _, ok := info["tailscale_tags"]; ok {
+				t.Error("userinfo has a tailscale_tags claim")
+			}
+		})
+	}
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	sum := sha256.Sum256([]byte("verifier"))
+	s256 := "S256:" + base64.RawURLEncoding.EncodeToString(sum[:])
+
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{name: "no challenge", challenge: "", verifier: "anything", want: true},
+		{name: "plain", challenge: "verifier", verifier: "verifier", want: true},
+		{name: "plain mismatch", challenge: "verifier", verifier: "other", want: false},
+		{name: "s256", challenge: s256, verifier: "verifier", want: true},
+		{name: "s256 mismatch", challenge: s256, verifier: "other", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyChallenge(tt.challenge, tt.verifier); got != tt.want {
+				t.Errorf("verifyChallenge = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
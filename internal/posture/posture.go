@@ -0,0 +1,145 @@
+package posture
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/tale/headplane/internal/tsnet"
+	"tailscale.com/util/cmpver"
+)
+
+// Policy is a set of posture rules loaded from a JSON file.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule describes the checks a node must pass. A rule only applies to nodes
+// matched by its selector; an empty selector matches every node.
+type Rule struct {
+	Name string `json:"name"`
+
+	// Selector fields. A node must match all non-empty fields.
+	Tags []string `json:"tags,omitempty"`
+	OS   []string `json:"os,omitempty"`
+
+	// Checks. Empty fields are skipped.
+	MinClientVersion string            `json:"minClientVersion,omitempty"`
+	AllowedOS        []string          `json:"allowedOS,omitempty"`
+	MinOSVersion     map[string]string `json:"minOSVersion,omitempty"`
+	RequireShieldsUp bool              `json:"requireShieldsUp,omitempty"`
+	RequiredTags     []string          `json:"requiredTags,omitempty"`
+}
+
+// Failure is a single failed check on a node.
+type Failure struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// Result is the outcome of evaluating a policy against a node.
+type Result struct {
+	Pass     bool      `json:"pass"`
+	Failures []Failure `json:"failures,omitempty"`
+}
+
+// Load reads and validates a posture policy from path.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read posture policy: %w", err)
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse posture policy: %w", err)
+	}
+
+	for i, r := range p.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("posture rule %d has no name", i)
+		}
+	}
+
+	return &p, nil
+}
+
+// Evaluate checks every node against the policy and returns the results
+// keyed by node public key.
+func (p *Policy) Evaluate(nodes map[string]*tsnet.Node) map[string]*Result {
+	results := make(map[string]*Result, len(nodes))
+	for id, n := range nodes {
+		res := &Result{Pass: true}
+		for _, r := range p.Rules {
+			if !r.matches(n) {
+				continue
+			}
+
+			for _, reason := range r.check(n) {
+				res.Failures = append(res.Failures, Failure{Rule: r.Name, Reason: reason})
+			}
+		}
+
+		res.Pass = len(res.Failures) == 0
+		results[id] = res
+	}
+
+	return results
+}
+
+func (r *Rule) matches(n *tsnet.Node) bool {
+	if len(r.OS) > 0 && !slices.Contains(r.OS, n.Node.Hostinfo.OS()) {
+		return false
+	}
+
+	if len(r.Tags) > 0 && !slices.ContainsFunc(r.Tags, func(t string) bool {
+		return slices.Contains(n.Node.Tags, t)
+	}) {
+		return false
+	}
+
+	return true
+}
+
+// check runs every configured check and returns the reasons for failure.
+func (r *Rule) check(n *tsnet.Node) []string {
+	var reasons []string
+	hi := n.Node.Hostinfo
+
+	if r.MinClientVersion != "" {
+		// IPNVersion carries a build suffix (1.80.2-t1234-g5678) we ignore.
+		v, _, _ := strings.Cut(hi.IPNVersion(), "-")
+		if v == "" {
+			reasons = append(reasons, "client version is unknown")
+		} else if cmpver.Less(v, r.MinClientVersion) {
+			reasons = append(reasons, fmt.Sprintf("client version %s is older than %s", v, r.MinClientVersion))
+		}
+	}
+
+	if len(r.AllowedOS) > 0 && !slices.Contains(r.AllowedOS, hi.OS()) {
+		reasons = append(reasons, fmt.Sprintf("operating system %q is not allowed", hi.OS()))
+	}
+
+	// On Linux the OS version is the kernel release.
+	if want, ok := r.MinOSVersion[hi.OS()]; ok {
+		if hi.OSVersion() == "" {
+			reasons = append(reasons, "operating system version is unknown")
+		} else if cmpver.Less(hi.OSVersion(), want) {
+			reasons = append(reasons, fmt.Sprintf("operating system version %s is older than %s", hi.OSVersion(), want))
+		}
+	}
+
+	if r.RequireShieldsUp && !hi.ShieldsUp() {
+		reasons = append(reasons, "shields up is disabled")
+	}
+
+	for _, tag := range r.RequiredTags {
+		if !slices.Contains(n.Node.Tags, tag) {
+			reasons = append(reasons, fmt.Sprintf("missing tag %s", tag))
+		}
+	}
+
+	return reasons
+}
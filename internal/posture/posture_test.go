@@ -0,0 +1,98 @@
+package posture
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tale/headplane/internal/tsnet"
+	"tailscale.com/tailcfg"
+)
+
+func node(tags []string, hi *tailcfg.Hostinfo) *tsnet.Node {
+	return &tsnet.Node{Node: &tailcfg.Node{Tags: tags, Hostinfo: hi.View()}}
+}
+
+func TestEvaluate(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		{Name: "client", MinClientVersion: "1.80.0"},
+		{Name: "servers", Tags: []string{"tag:server"}, AllowedOS: []string{"linux"}, MinOSVersion: map[string]string{"linux": "6.1"}, RequireShieldsUp: true},
+		{Name: "prod", OS: []string{"windows"}, RequiredTags: []string{"tag:managed"}},
+	}}
+
+	tests := []struct {
+		name string
+		node *tsnet.Node
+		want *Result
+	}{
+		{
+			name: "passes",
+			node: node([]string{"tag:server"}, &tailcfg.Hostinfo{IPNVersion: "1.88.2-t1234-gabc", OS: "linux", OSVersion: "6.8.0", ShieldsUp: true}),
+			want: &Result{Pass: true},
+		},
+		{
+			name: "old client ignores build suffix",
+			node: node(nil, &tailcfg.Hostinfo{IPNVersion: "1.78.1-t99", OS: "macOS"}),
+			want: &Result{Failures: []Failure{{Rule: "client", Reason: "client version 1.78.1 is older than 1.80.0"}}},
+		},
+		{
+			name: "unknown client",
+			node: node(nil, &tailcfg.Hostinfo{OS: "macOS"}),
+			want: &Result{Failures: []Failure{{Rule: "client", Reason: "client version is unknown"}}},
+		},
+		{
+			name: "server checks",
+			node: node([]string{"tag:server"}, &tailcfg.Hostinfo{IPNVersion: "1.88.2", OS: "linux", OSVersion: "5.15.0"}),
+			want: &Result{Failures: []Failure{
+				{Rule: "servers", Reason: "operating system version 5.15.0 is older than 6.1"},
+				{Rule: "servers", Reason: "shields up is disabled"},
+			}},
+		},
+		{
+			name: "disallowed os",
+			node: node([]string{"tag:server"}, &tailcfg.Hostinfo{IPNVersion: "1.88.2", OS: "freebsd", ShieldsUp: true}),
+			want: &Result{Failures: []Failure{{Rule: "servers", Reason: `operating system "freebsd" is not allowed`}}},
+		},
+		{
+			name: "missing tag",
+			node: node(nil, &tailcfg.Hostinfo{IPNVersion: "1.88.2", OS: "windows"}),
+			want: &Result{Failures: []Failure{{Rule: "prod", Reason: "missing tag tag:managed"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.Evaluate(map[string]*tsnet.Node{"n": tt.node})["n"]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "valid", data: `{"rules": [{"name": "client", "minClientVersion": "1.80.0"}]}`},
+		{name: "unnamed rule", data: `{"rules": [{"minClientVersion": "1.80.0"}]}`, wantErr: true},
+		{name: "invalid json", data: `{"rules": [`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "posture.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxBody caps how much of an HTTP response is read for body matching.
+const maxBody = 1 << 20
+
+var queryTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+func (p Probe) run(ctx context.Context, d Dialer, t target, addr string) error {
+	switch p.Type {
+	case TCP:
+		conn, err := d.Dial(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	case HTTP:
+		return p.checkHTTP(ctx, d, t, addr)
+	case DNS:
+		return p.checkDNS(ctx, d, addr)
+	}
+
+	return fmt.Errorf("unknown probe type %q", p.Type)
+}
+
+// checkHTTP requests the probe path and checks the status code and, if
+// configured, that the body contains the expected string. The request uses
+// the node's MagicDNS name as the host so virtual hosts and SNI work.
+func (p Probe) checkHTTP(ctx context.Context, d Dialer, t target, addr string) error {
+	host := t.name
+	if host == "" {
+		host = t.addr.String()
+	}
+
+	scheme := "http"
+	if p.HTTPS {
+		scheme = "https"
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.Dial(ctx, network, addr)
+		},
+		TLSClientConfig:   &tls.Config{ServerName: host, InsecureSkipVerify: p.Insecure},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(p.Port))), p.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != p.ExpectStatus {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	if p.BodyMatch == "" {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxBody))
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(string(body), p.BodyMatch) {
+		return errors.New("response body did not match")
+	}
+
+	return nil
+}
+
+// checkDNS sends the configured query to the node over UDP and expects a
+// successful response with at least one answer.
+func (p Probe) checkDNS(ctx context.Context, d Dialer, addr string) error {
+	qtype, ok := queryTypes[strings.ToUpper(p.QueryType)]
+	if !ok {
+		return fmt.Errorf("unsupported query type %q", p.QueryType)
+	}
+
+	query := p.Query
+	if !strings.HasSuffix(query, ".") {
+		query += "."
+	}
+
+	name, err := dnsmessage.NewName(query)
+	if err != nil {
+		return err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET})
+	msg, err := b.Finish()
+	if err != nil {
+		return err
+	}
+
+	conn, err := d.Dial(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1232)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	var parser dnsmessage.Parser
+	h, err := parser.Start(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("query failed with %s", h.RCode)
+	}
+
+	if err := parser.SkipAllQuestions(); err != nil {
+		return err
+	}
+
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		return err
+	}
+
+	if len(answers) == 0 {
+		return errors.New("no answers")
+	}
+
+	return nil
+}
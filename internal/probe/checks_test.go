@@ -0,0 +1,131 @@
+package probe
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// hostDialer dials over the host network in place of the tailnet.
+type hostDialer struct{}
+
+func (hostDialer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+func TestRunHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			io.WriteString(w, "status: ok")
+		case "/moved":
+			http.Redirect(w, r, "/health", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	addr := srv.Listener.Addr().String()
+	tests := []struct {
+		name    string
+		probe   Probe
+		wantErr bool
+	}{
+		{name: "status matches", probe: Probe{Path: "/health", ExpectStatus: 200}},
+		{name: "body matches", probe: Probe{Path: "/health", ExpectStatus: 200, BodyMatch: "ok"}},
+		{name: "body does not match", probe: Probe{Path: "/health", ExpectStatus: 200, BodyMatch: "degraded"}, wantErr: true},
+		{name: "unexpected status", probe: Probe{Path: "/missing", ExpectStatus: 200}, wantErr: true},
+		{name: "redirects are not followed", probe: Probe{Path: "/moved", ExpectStatus: 302}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.probe
+			p.Type = HTTP
+			p.Port = 80
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			tgt := target{name: "web.ts.net", addr: netip.MustParseAddr("100.64.0.1")}
+			err := p.run(ctx, hostDialer{}, tgt, addr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := ln.Addr().String()
+	ln.Close()
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr bool
+	}{
+		{name: "listening", addr: ln.Addr().String()},
+		{name: "closed port", addr: open, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := Probe{Type: TCP}.run(ctx, hostDialer{}, target{}, tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelects(t *testing.T) {
+	tgt := target{
+		name: "db.tailnet.ts.net",
+		host: "db-host",
+		tags: []string{"tag:prod"},
+		addr: netip.MustParseAddr("100.64.0.5"),
+	}
+
+	tests := []struct {
+		name  string
+		probe Probe
+		want  bool
+	}{
+		{name: "magicdns name", probe: Probe{Nodes: []string{"db.tailnet.ts.net"}}, want: true},
+		{name: "short name", probe: Probe{Nodes: []string{"DB"}}, want: true},
+		{name: "hostname", probe: Probe{Nodes: []string{"db-host"}}, want: true},
+		{name: "address", probe: Probe{Nodes: []string{"100.64.0.5"}}, want: true},
+		{name: "tag", probe: Probe{Tags: []string{"tag:dev", "tag:prod"}}, want: true},
+		{name: "no match", probe: Probe{Nodes: []string{"web"}, Tags: []string{"tag:dev"}}},
+		{name: "no selectors"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.probe.selects(tgt); got != tt.want {
+				t.Errorf("selects() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
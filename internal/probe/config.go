@@ -0,0 +1,148 @@
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Type selects how a probe checks its target.
+type Type string
+
+const (
+	TCP  Type = "tcp"
+	HTTP Type = "http"
+	DNS  Type = "dns"
+)
+
+// Probe is a check run against every node it selects. Nodes are matched by
+// MagicDNS name, hostname or Tailscale IP; tags match any node carrying one
+// of them. A probe with neither runs against no nodes.
+type Probe struct {
+	Name  string   `json:"name"`
+	Type  Type     `json:"type"`
+	Nodes []string `json:"nodes,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Port  uint16   `json:"port"`
+
+	Interval time.Duration `json:"-"`
+	Timeout  time.Duration `json:"-"`
+
+	// HTTP options.
+	HTTPS        bool   `json:"https,omitempty"`
+	Insecure     bool   `json:"insecure,omitempty"`
+	Path         string `json:"path,omitempty"`
+	ExpectStatus int    `json:"expectStatus,omitempty"`
+	BodyMatch    string `json:"bodyMatch,omitempty"`
+
+	// DNS options.
+	Query     string `json:"query,omitempty"`
+	QueryType string `json:"queryType,omitempty"`
+}
+
+// rawProbe mirrors Probe with durations as strings for decoding.
+type rawProbe struct {
+	Probe
+	Interval string `json:"interval"`
+	Timeout  string `json:"timeout"`
+}
+
+// Load reads and validates probes from a JSON file.
+func Load(path string) ([]Probe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read probes: %w", err)
+	}
+
+	var file struct {
+		Probes []rawProbe `json:"probes"`
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse probes: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	probes := make([]Probe, 0, len(file.Probes))
+	for i, raw := range file.Probes {
+		p := raw.Probe
+		if p.Name == "" {
+			return nil, fmt.Errorf("probe %d has no name", i)
+		}
+
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate probe %q", p.Name)
+		}
+		seen[p.Name] = true
+
+		p.Interval, err = parseDuration(raw.Interval, time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("probe %q has an invalid interval: %w", p.Name, err)
+		}
+
+		p.Timeout, err = parseDuration(raw.Timeout, 5*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("probe %q has an invalid timeout: %w", p.Name, err)
+		}
+
+		switch p.Type {
+		case TCP:
+		case HTTP:
+			if p.Path == "" {
+				p.Path = "/"
+			}
+
+			if p.ExpectStatus == 0 {
+				p.ExpectStatus = 200
+			}
+		case DNS:
+			if p.Query == "" {
+				return nil, fmt.Errorf("probe %q needs a query", p.Name)
+			}
+
+			if p.QueryType == "" {
+				p.QueryType = "A"
+			}
+		default:
+			return nil, fmt.Errorf("probe %q has unknown type %q", p.Name, p.Type)
+		}
+
+		if p.Port == 0 {
+			p.Port = defaultPort(p)
+		}
+
+		probes = append(probes, p)
+	}
+
+	return probes, nil
+}
+
+func defaultPort(p Probe) uint16 {
+	switch {
+	case p.Type == DNS:
+		return 53
+	case p.Type == HTTP && p.HTTPS:
+		return 443
+	default:
+		return 80
+	}
+}
+
+// parseDuration parses a positive duration, returning def if s is empty.
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("%s is not positive", s)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,97 @@
+package probe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		wantInterval time.Duration
+		wantTimeout  time.Duration
+		wantPort     uint16
+		wantErr      string
+	}{
+		{
+			name:         "defaults",
+			config:       `{"probes":[{"name":"web","type":"http","https":true}]}`,
+			wantInterval: time.Minute,
+			wantTimeout:  5 * time.Second,
+			wantPort:     443,
+		},
+		{
+			name:         "explicit durations",
+			config:       `{"probes":[{"name":"ssh","type":"tcp","port":22,"interval":"30s","timeout":"2s"}]}`,
+			wantInterval: 30 * time.Second,
+			wantTimeout:  2 * time.Second,
+			wantPort:     22,
+		},
+		{
+			name:    "zero interval",
+			config:  `{"probes":[{"name":"ssh","type":"tcp","interval":"0s"}]}`,
+			wantErr: `probe "ssh" has an invalid interval`,
+		},
+		{
+			name:    "negative interval",
+			config:  `{"probes":[{"name":"ssh","type":"tcp","interval":"-1m"}]}`,
+			wantErr: `probe "ssh" has an invalid interval`,
+		},
+		{
+			name:    "zero timeout",
+			config:  `{"probes":[{"name":"ssh","type":"tcp","timeout":"0s"}]}`,
+			wantErr: `probe "ssh" has an invalid timeout`,
+		},
+		{
+			name:    "unparseable interval",
+			config:  `{"probes":[{"name":"ssh","type":"tcp","interval":"often"}]}`,
+			wantErr: `probe "ssh" has an invalid interval`,
+		},
+		{
+			name:    "dns without query",
+			config:  `{"probes":[{"name":"dns","type":"dns"}]}`,
+			wantErr: "needs a query",
+		},
+		{
+			name:    "unknown type",
+			config:  `{"probes":[{"name":"x","type":"icmp"}]}`,
+			wantErr: "unknown type",
+		},
+		{
+			name:    "duplicate name",
+			config:  `{"probes":[{"name":"x","type":"tcp"},{"name":"x","type":"tcp"}]}`,
+			wantErr: "duplicate probe",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "probes.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			probes, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := probes[0]
+			if p.Interval != tt.wantInterval || p.Timeout != tt.wantTimeout || p.Port != tt.wantPort {
+				t.Errorf("got interval %s, timeout %s, port %d, want %s, %s, %d",
+					p.Interval, p.Timeout, p.Port, tt.wantInterval, tt.wantTimeout, tt.wantPort)
+			}
+		})
+	}
+}
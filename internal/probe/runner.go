@@ -0,0 +1,282 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/metrics"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/types/netmap"
+)
+
+// Dialer opens connections over the tailnet. *tsnet.Server satisfies it.
+type Dialer interface {
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Result is the outcome of the most recent run of a probe against a node.
+type Result struct {
+	Probe     string    `json:"probe"`
+	Type      Type      `json:"type"`
+	Target    string    `json:"target"`
+	OK        bool      `json:"ok"`
+	LatencyMs float64   `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// target is a node the probes may run against.
+type target struct {
+	id     string
+	name   string
+	host   string
+	tags   []string
+	addr   netip.Addr
+	online bool
+}
+
+// Runner runs every probe on its own interval against the nodes in the
+// latest netmap.
+type Runner struct {
+	probes []Probe
+	dial   Dialer
+
+	// ready is closed by the first netmap, before which there is nothing to
+	// probe.
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu      sync.Mutex
+	targets map[string]target
+	results map[string]map[string]*Result // node -> probe -> result
+}
+
+// NewRunner returns a runner for probes that dials through d.
+func NewRunner(probes []Probe, d Dialer) *Runner {
+	return &Runner{
+		probes:  probes,
+		dial:    d,
+		ready:   make(chan struct{}),
+		targets: make(map[string]target),
+		results: make(map[string]map[string]*Result),
+	}
+}
+
+// Observe updates the probe targets from a netmap. Results for nodes that
+// have left the tailnet are dropped.
+func (r *Runner) Observe(nm *netmap.NetworkMap) {
+	targets := make(map[string]target, len(nm.Peers))
+	for _, n := range nm.Peers {
+		if !n.Valid() {
+			continue
+		}
+
+		t := target{
+			id:     n.Key().String(),
+			name:   strings.TrimSuffix(n.Name(), "."),
+			tags:   n.Tags().AsSlice(),
+			online: n.Online().Get(),
+		}
+
+		if hi := n.Hostinfo(); hi.Valid() {
+			t.host = hi.Hostname()
+		}
+
+		for _, p := range n.Addresses().All() {
+			if !t.addr.IsValid() || (p.Addr().Is4() && !t.addr.Is4()) {
+				t.addr = p.Addr()
+			}
+		}
+
+		if t.addr.IsValid() {
+			targets[t.id] = t
+		}
+	}
+
+	r.mu.Lock()
+	for id, old := range r.targets {
+		if _, ok := targets[id]; ok {
+			continue
+		}
+
+		for name := range r.results[id] {
+			key := metrics.ProbeLabels{Probe: name, Node: old.label()}
+			metrics.ProbeSuccess.Delete(key)
+			metrics.ProbeDuration.Delete(key)
+		}
+
+		delete(r.results, id)
+	}
+
+	r.targets = targets
+	r.mu.Unlock()
+
+	r.readyOnce.Do(func() { close(r.ready) })
+}
+
+// Run starts every probe and blocks until ctx is done. The first run waits
+// for the first netmap so that it has nodes to probe.
+func (r *Runner) Run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-r.ready:
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range r.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.loop(ctx, p)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Results returns the latest results keyed by node key.
+func (r *Runner) Results() map[string][]Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make(map[string][]Result, len(r.results))
+	for id, byProbe := range r.results {
+		list := make([]Result, 0, len(byProbe))
+		for _, res := range byProbe {
+			list = append(list, *res)
+		}
+
+		slices.SortFunc(list, func(a, b Result) int { return strings.Compare(a.Probe, b.Probe) })
+		out[id] = list
+	}
+
+	return out
+}
+
+func (r *Runner) loop(ctx context.Context, p Probe) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.runOnce(ctx, p)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce runs p against every node it selects. Offline nodes are marked
+// as failed without dialing them.
+func (r *Runner) runOnce(ctx context.Context, p Probe) {
+	log := util.GetLogger()
+
+	r.mu.Lock()
+	var targets []target
+	for _, t := range r.targets {
+		if p.selects(t) {
+			targets = append(targets, t)
+		}
+	}
+	r.mu.Unlock()
+
+	const maxParallel = 8
+	sema := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for _, t := range targets {
+		wg.Add(1)
+		sema <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sema }()
+
+			addr := net.JoinHostPort(t.addr.String(), strconv.Itoa(int(p.Port)))
+			res := &Result{Probe: p.Name, Type: p.Type, Target: addr, Time: time.Now()}
+
+			if !t.online {
+				res.Error = "node is offline"
+			} else {
+				pctx, cancel := context.WithTimeout(ctx, p.Timeout)
+				err := p.run(pctx, r.dial, t, addr)
+				cancel()
+
+				res.LatencyMs = float64(time.Since(res.Time).Microseconds()) / 1000
+				if err != nil {
+					log.Debug("Probe %s failed for %s: %s", p.Name, t.label(), err)
+					res.Error = err.Error()
+				} else {
+					res.OK = true
+				}
+			}
+
+			r.record(t, res)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (r *Runner) record(t target, res *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The node may have been removed while the probe was running.
+	if _, ok := r.targets[t.id]; !ok {
+		return
+	}
+
+	if r.results[t.id] == nil {
+		r.results[t.id] = make(map[string]*Result)
+	}
+	r.results[t.id][res.Probe] = res
+
+	key := metrics.ProbeLabels{Probe: res.Probe, Node: t.label()}
+	success := int64(0)
+	if res.OK {
+		success = 1
+	}
+
+	metrics.ProbeSuccess.SetInt(key, success)
+	metrics.ProbeDuration.SetFloat(key, res.LatencyMs/1000)
+}
+
+// selects reports whether p should run against t.
+func (p Probe) selects(t target) bool {
+	for _, n := range p.Nodes {
+		if strings.EqualFold(n, t.name) || strings.EqualFold(n, t.host) || n == t.addr.String() {
+			return true
+		}
+
+		if short, _, ok := strings.Cut(t.name, "."); ok && strings.EqualFold(n, short) {
+			return true
+		}
+	}
+
+	for _, tag := range p.Tags {
+		if slices.Contains(t.tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// label is the node name used in metrics, preferring the MagicDNS name.
+func (t target) label() string {
+	if t.name != "" {
+		return t.name
+	}
+
+	return t.addr.String()
+}
@@ -0,0 +1,86 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+func TestRunnerWaitsForNetMap(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	online := true
+	nm := &netmap.NetworkMap{Peers: []tailcfg.NodeView{(&tailcfg.Node{
+		Key:       key.NewNode().Public(),
+		Name:      "web.example.ts.net.",
+		Addresses: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		Online:    &online,
+	}).View()}}
+
+	tests := []struct {
+		name string
+		// observe delivers the netmap after Run has started.
+		observe bool
+		want    int
+	}{
+		{name: "first run follows the first netmap", observe: true, want: 1},
+		{name: "stops while waiting for a netmap"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The interval is far longer than the test, so a result can only
+			// come from the first run.
+			r := NewRunner([]Probe{{
+				Name:     "ssh",
+				Type:     TCP,
+				Nodes:    []string{"web"},
+				Port:     uint16(port),
+				Interval: time.Hour,
+				Timeout:  5 * time.Second,
+			}}, hostDialer{})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				r.Run(ctx)
+			}()
+
+			if tt.observe {
+				time.Sleep(50 * time.Millisecond)
+				r.Observe(nm)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for len(r.Results()) < tt.want && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			cancel()
+			<-done
+
+			results := r.Results()
+			if len(results) != tt.want {
+				t.Fatalf("got results for %d nodes, want %d", len(results), tt.want)
+			}
+
+			for _, list := range results {
+				if len(list) != 1 || !list[0].OK {
+					t.Errorf("results = %+v, want one passing result", list)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package promsd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tale/headplane/internal/inventory"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/netmap"
+)
+
+// Config controls which ports are listed for each node. Nodes get the
+// ports of every tag they carry, or DefaultPorts if none of their tags has
+// ports configured. Address is "ip" (default) or "name".
+type Config struct {
+	Ports        map[string][]uint16 `json:"ports"`
+	DefaultPorts []uint16            `json:"defaultPorts"`
+	Address      string              `json:"address"`
+	FileSD       string              `json:"fileSD"`
+}
+
+// Group is a Prometheus target group as used by http_sd and file_sd.
+type Group struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// Load reads a service discovery config from a JSON file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service discovery config: %w", err)
+	}
+
+	c := new(Config)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse service discovery config: %w", err)
+	}
+
+	switch c.Address {
+	case "":
+		c.Address = "ip"
+	case "ip", "name":
+	default:
+		return nil, fmt.Errorf("unknown address type %q", c.Address)
+	}
+
+	return c, nil
+}
+
+// Discovery keeps the target groups for the latest netmap. It serves them
+// as an http_sd endpoint and optionally writes them to a file_sd file.
+type Discovery struct {
+	cfg *Config
+
+	mu   sync.Mutex
+	data []byte
+}
+
+// New returns a Discovery that lists no targets until the first netmap.
+func New(cfg *Config) *Discovery {
+	return &Discovery{cfg: cfg, data: []byte("[]")}
+}
+
+// Observe rebuilds the target groups from a netmap, rewriting the file_sd
+// file when they changed.
+func (d *Discovery) Observe(nm *netmap.NetworkMap) {
+	data, err := json.Marshal(d.Groups(inventory.FromNetMap(nm)))
+	if err != nil {
+		util.GetLogger().Error("Failed to encode service discovery targets: %s", err)
+		return
+	}
+
+	d.mu.Lock()
+	changed := !bytes.Equal(d.data, data)
+	d.data = data
+	d.mu.Unlock()
+
+	if changed && d.cfg.FileSD != "" {
+		if err := atomicfile.WriteFile(d.cfg.FileSD, data, 0644); err != nil {
+			util.GetLogger().Error("Failed to write file_sd targets: %s", err)
+		}
+	}
+}
+
+// ServeHTTP serves the current target groups in the http_sd format.
+func (d *Discovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	data := d.data
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Groups returns the target groups for every host that has ports to
+// scrape. With IP addresses there is one group per address, labeled with
+// its family, so that IPv4 and IPv6 targets can be told apart.
+func (d *Discovery) Groups(hosts []inventory.Host) []Group {
+	groups := []Group{}
+	for _, h := range hosts {
+		ports := d.ports(h)
+		if len(ports) == 0 {
+			continue
+		}
+
+		if d.cfg.Address == "name" {
+			groups = append(groups, group(h.Name, ports, labels(h)))
+			continue
+		}
+
+		for _, ip := range h.IPs() {
+			l := labels(h)
+			l["__meta_tailscale_address_family"] = "ipv6"
+			if ip.Is4() {
+				l["__meta_tailscale_address_family"] = "ipv4"
+			}
+
+			groups = append(groups, group(ip.String(), ports, l))
+		}
+	}
+
+	return groups
+}
+
+func group(addr string, ports []uint16, labels map[string]string) Group {
+	g := Group{Labels: labels}
+	for _, port := range ports {
+		g.Targets = append(g.Targets, net.JoinHostPort(addr, strconv.Itoa(int(port))))
+	}
+
+	return g
+}
+
+func (d *Discovery) ports(h inventory.Host) []uint16 {
+	var ports []uint16
+	for _, tag := range h.Tags {
+		ports = append(ports, d.cfg.Ports[tag]...)
+	}
+
+	if len(ports) == 0 {
+		ports = d.cfg.DefaultPorts
+	}
+
+	ports = slices.Clone(ports)
+	slices.Sort(ports)
+	return slices.Compact(ports)
+}
+
+// labels returns the __meta_tailscale_* labels for a host. Lists are
+// joined with commas and wrapped in them, like Prometheus' own discovery
+// mechanisms do, so relabel rules can match ".*,tag:foo,.*".
+func labels(h inventory.Host) map[string]string {
+	routes := make([]string, len(h.Routes))
+	for i, r := range h.Routes {
+		routes[i] = r.String()
+	}
+
+	return map[string]string{
+		"__meta_tailscale_name":      h.Name,
+		"__meta_tailscale_hostname":  h.Hostname,
+		"__meta_tailscale_user":      h.User,
+		"__meta_tailscale_tags":      list(h.Tags),
+		"__meta_tailscale_os":        h.OS,
+		"__meta_tailscale_routes":    list(routes),
+		"__meta_tailscale_ipv4":      addrString(h.IPv4),
+		"__meta_tailscale_ipv6":      addrString(h.IPv6),
+		"__meta_tailscale_online":    strconv.FormatBool(h.Online),
+		"__meta_tailscale_exit_node": strconv.FormatBool(h.ExitNode),
+	}
+}
+
+func list(items []string) string {
+	if len(items) == 0 {
+		return ""
+	}
+
+	return "," + strings.Join(items, ",") + ","
+}
+
+func addrString(ip netip.Addr) string {
+	if !ip.IsValid() {
+		return ""
+	}
+
+	return ip.String()
+}
@@ -0,0 +1,159 @@
+package promsd
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tale/headplane/internal/inventory"
+)
+
+func TestGroups(t *testing.T) {
+	dual := inventory.Host{
+		Name:     "db.ts.net",
+		Hostname: "db",
+		Tags:     []string{"tag:db", "tag:server"},
+		IPv4:     netip.MustParseAddr("100.64.0.1"),
+		IPv6:     netip.MustParseAddr("fd7a:115c:a1e0::1"),
+	}
+	v4only := inventory.Host{Name: "web.ts.net", IPv4: netip.MustParseAddr("100.64.0.2")}
+	untagged := inventory.Host{Name: "laptop.ts.net", IPv4: netip.MustParseAddr("100.64.0.3")}
+
+	ports := map[string][]uint16{"tag:server": {9100}, "tag:db": {9100, 9187}}
+
+	tests := []struct {
+		name     string
+		cfg      Config
+		hosts    []inventory.Host
+		want     [][]string
+		families []string
+	}{
+		{
+			name:     "one group per address",
+			cfg:      Config{Ports: ports, Address: "ip"},
+			hosts:    []inventory.Host{dual},
+			want:     [][]string{{"100.64.0.1:9100", "100.64.0.1:9187"}, {"[fd7a:115c:a1e0::1]:9100", "[fd7a:115c:a1e0::1]:9187"}},
+			families: []string{"ipv4", "ipv6"},
+		},
+		{
+			name:     "ipv4 only host",
+			cfg:      Config{DefaultPorts: []uint16{80}, Address: "ip"},
+			hosts:    []inventory.Host{v4only},
+			want:     [][]string{{"100.64.0.2:80"}},
+			families: []string{"ipv4"},
+		},
+		{
+			name:     "names",
+			cfg:      Config{Ports: ports, Address: "name"},
+			hosts:    []inventory.Host{dual},
+			want:     [][]string{{"db.ts.net:9100", "db.ts.net:9187"}},
+			families: []string{""},
+		},
+		{
+			name:  "hosts without ports are skipped",
+			cfg:   Config{Ports: ports, Address: "ip"},
+			hosts: []inventory.Host{untagged},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := New(&tt.cfg).Groups(tt.hosts)
+
+			var got [][]string
+			var families []string
+			for _, g := range groups {
+				got = append(got, g.Targets)
+				families = append(families, g.Labels["__meta_tailscale_address_family"])
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targets = %v, want %v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(families, tt.families) {
+				t.Errorf("families = %v, want %v", families, tt.families)
+			}
+		})
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		host inventory.Host
+		want map[string]string
+	}{
+		{
+			name: "lists are wrapped in commas",
+			host: inventory.Host{
+				Name:   "db.ts.net",
+				Tags:   []string{"tag:db", "tag:prod"},
+				Routes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+				IPv4:   netip.MustParseAddr("100.64.0.1"),
+				Online: true,
+			},
+			want: map[string]string{
+				"__meta_tailscale_name":      "db.ts.net",
+				"__meta_tailscale_hostname":  "",
+				"__meta_tailscale_user":      "",
+				"__meta_tailscale_tags":      ",tag:db,tag:prod,",
+				"__meta_tailscale_os":        "",
+				"__meta_tailscale_routes":    ",10.0.0.0/24,",
+				"__meta_tailscale_ipv4":      "100.64.0.1",
+				"__meta_tailscale_ipv6":      "",
+				"__meta_tailscale_online":    "true",
+				"__meta_tailscale_exit_node": "false",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := labels(tt.host); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("labels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		wantAddress string
+		wantErr     string
+	}{
+		{name: "default address", config: `{}`, wantAddress: "ip"},
+		{name: "names", config: `{"address":"name"}`, wantAddress: "name"},
+		{name: "unknown address", config: `{"address":"mac"}`, wantErr: "unknown address type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sd.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.Address != tt.wantAddress {
+				t.Errorf("Address = %q, want %q", c.Address, tt.wantAddress)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Rule allows connections to a host, domain suffix or network on one port
+// or, with "*", on every port.
+type Rule struct {
+	host   string
+	suffix string
+	prefix netip.Prefix
+	port   string
+}
+
+// ParseAllowlist parses entries of the form host:port, where host is a
+// name, a "*.domain" wildcard, an IP address or a CIDR prefix and port is
+// a number or "*". IPv6 hosts must be bracketed.
+func ParseAllowlist(entries []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(entries))
+	for _, e := range entries {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(e))
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy allowlist entry %q: %w", e, err)
+		}
+
+		r := Rule{port: port}
+		switch {
+		case strings.HasPrefix(host, "*."):
+			r.suffix = strings.ToLower(host[1:])
+		case strings.Contains(host, "/"):
+			if r.prefix, err = netip.ParsePrefix(host); err != nil {
+				return nil, fmt.Errorf("invalid proxy allowlist entry %q: %w", e, err)
+			}
+		default:
+			if ip, err := netip.ParseAddr(host); err == nil {
+				r.prefix = netip.PrefixFrom(ip, ip.BitLen())
+			} else {
+				r.host = strings.ToLower(host)
+			}
+		}
+
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// Allowed reports whether any rule permits a connection to addr. Names are
+// matched as requested by the client, before they are resolved.
+func Allowed(rules []Rule, addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip, ipErr := netip.ParseAddr(host)
+
+	for _, r := range rules {
+		if r.port != "*" && r.port != port {
+			continue
+		}
+
+		switch {
+		case r.prefix.IsValid():
+			if ipErr == nil && r.prefix.Contains(ip.Unmap()) {
+				return true
+			}
+		case r.suffix != "":
+			if strings.HasSuffix(host, r.suffix) {
+				return true
+			}
+		case r.host == host:
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr string
+	}{
+		{name: "empty"},
+		{name: "every kind", entries: []string{"web.ts.net:80", "*.ts.net:*", "100.64.0.1:22", "100.64.0.0/10:*", "[fd7a::1]:443"}},
+		{name: "missing port", entries: []string{"web.ts.net"}, wantErr: `invalid proxy allowlist entry "web.ts.net"`},
+		{name: "bad prefix", entries: []string{"100.64.0.0/99:*"}, wantErr: `invalid proxy allowlist entry "100.64.0.0/99:*"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseAllowlist(tt.entries)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseAllowlist error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseAllowlist: %v", err)
+			}
+
+			if len(rules) != len(tt.entries) {
+				t.Errorf("len(rules) = %d, want %d", len(rules), len(tt.entries))
+			}
+		})
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	rules, err := ParseAllowlist([]string{
+		"headscale.ts.net:8080",
+		"*.internal.ts.net:*",
+		"100.64.0.0/10:22",
+		"[fd7a:115c:a1e0::1]:443",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"headscale.ts.net:8080", true},
+		{"HEADSCALE.ts.net.:8080", true},
+		{"headscale.ts.net:80", false},
+		{"db.internal.ts.net:5432", true},
+		{"internal.ts.net:5432", false},
+		{"100.100.1.1:22", true},
+		{"100.100.1.1:80", false},
+		{"192.168.1.1:22", false},
+		{"[::ffff:100.64.0.1]:22", true},
+		{"[fd7a:115c:a1e0::1]:443", true},
+		{"[fd7a:115c:a1e0::2]:443", false},
+		{"no-port", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := Allowed(rules, tt.addr); got != tt.want {
+				t.Errorf("Allowed(%q) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
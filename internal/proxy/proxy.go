@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/net/proxymux"
+	"tailscale.com/net/socks5"
+)
+
+// Username is the fixed user name clients authenticate with.
+const Username = "headplane"
+
+// Dialer opens connections over the tailnet. *tsnet.Server satisfies it.
+type Dialer interface {
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Server is a SOCKS5 and HTTP CONNECT proxy into the tailnet. Both
+// protocols share one listener and require the same credential.
+type Server struct {
+	dial     Dialer
+	password string
+	allow    []Rule
+}
+
+// New returns a proxy that dials through d and only connects to
+// destinations permitted by allow.
+func New(d Dialer, password string, allow []Rule) *Server {
+	return &Server{dial: d, password: password, allow: allow}
+}
+
+// Listen opens the proxy listener. The address must be on a loopback
+// interface so the proxy is never reachable from the network.
+func Listen(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("proxy must listen on a loopback address, not %s", host)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// Serve handles connections on ln until it is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	log := util.GetLogger()
+	socksLn, httpLn := proxymux.SplitSOCKSAndHTTP(ln)
+
+	socks := &socks5.Server{
+		Logf:     log.Debug,
+		Dialer:   s.dialAllowed,
+		Username: Username,
+		Password: s.password,
+	}
+
+	errc := make(chan error, 2)
+	go func() { errc <- socks.Serve(socksLn) }()
+	go func() { errc <- http.Serve(httpLn, http.HandlerFunc(s.serveConnect)) }()
+	return <-errc
+}
+
+func (s *Server) dialAllowed(ctx context.Context, network, addr string) (net.Conn, error) {
+	if !Allowed(s.allow, addr) {
+		util.GetLogger().Debug("Proxy denied connection to %s", addr)
+		return nil, fmt.Errorf("destination %s is not allowed", addr)
+	}
+
+	return s.dial.Dial(ctx, network, addr)
+}
+
+// serveConnect handles HTTP CONNECT requests. Other methods are refused.
+func (s *Server) serveConnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, pass, ok := proxyAuth(r)
+	if !ok || user != Username || subtle.ConstantTimeCompare([]byte(pass), []byte(s.password)) != 1 {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="hp_agent"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	if !Allowed(s.allow, r.Host) {
+		http.Error(w, "destination not allowed", http.StatusForbidden)
+		return
+	}
+
+	upstream, err := s.dial.Dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	splice(conn, buf.Reader, upstream)
+}
+
+func proxyAuth(r *http.Request) (user, pass string, ok bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		return "", "", false
+	}
+
+	// BasicAuth only reads the Authorization header.
+	req := &http.Request{Header: http.Header{"Authorization": {auth}}}
+	return req.BasicAuth()
+}
+
+// splice copies data both ways until either side is done. Data the client
+// sent after the CONNECT request is still buffered in br.
+func splice(client net.Conn, br *bufio.Reader, upstream net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		io.Copy(upstream, br)
+		if c, ok := upstream.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		io.Copy(client, upstream)
+		if c, ok := client.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
+		}
+	}()
+
+	wg.Wait()
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// hostDialer dials on the host network in place of the tailnet.
+type hostDialer struct{ net.Dialer }
+
+func (d *hostDialer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.DialContext(ctx, network, addr)
+}
+
+func TestListen(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{addr: "127.0.0.1:0"},
+		{addr: "localhost:0"},
+		{addr: "0.0.0.0:0", wantErr: true},
+		{addr: "192.0.2.1:0", wantErr: true},
+		{addr: "127.0.0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			ln, err := Listen(tt.addr)
+			if ln != nil {
+				ln.Close()
+			}
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Listen(%q) error = %v, wantErr %v", tt.addr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServeConnect(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	rules, err := ParseAllowlist([]string{echo.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go New(&hostDialer{}, "secret", rules).Serve(ln)
+
+	auth := func(pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(Username+":"+pass))
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		auth   string
+		want   int
+	}{
+		{name: "tunnel", method: "CONNECT", target: echo.Addr().String(), auth: auth("secret"), want: http.StatusOK},
+		{name: "no credentials", method: "CONNECT", target: echo.Addr().String(), want: http.StatusProxyAuthRequired},
+		{name: "wrong password", method: "CONNECT", target: echo.Addr().String(), auth: auth("wrong"), want: http.StatusProxyAuthRequired},
+		{name: "not allowed", method: "CONNECT", target: "127.0.0.1:1", auth: auth("secret"), want: http.StatusForbidden},
+		{name: "not connect", method: "GET", target: "http://" + echo.Addr().String() + "/", auth: auth("secret"), want: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			fmt.Fprintf(c, "%s %s HTTP/1.1\r\nHost: %s\r\n", tt.method, tt.target, echo.Addr())
+			if tt.auth != "" {
+				fmt.Fprintf(c, "Proxy-Authorization: %s\r\n", tt.auth)
+			}
+			io.WriteString(c, "\r\n")
+
+			br := bufio.NewReader(c)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			io.WriteString(c, "ping\n")
+			line, err := br.ReadString('\n')
+			if err != nil || strings.TrimSpace(line) != "ping" {
+				t.Errorf("echo = %q, %v, want ping", line, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package serve
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Identity headers set on every proxied request. They match the headers
+// set by `tailscale serve`, and any the client sent are removed.
+const (
+	UserLoginHeader   = "Tailscale-User-Login"
+	UserNameHeader    = "Tailscale-User-Name"
+	UserPictureHeader = "Tailscale-User-Profile-Pic"
+	NodeNameHeader    = "Tailscale-Node-Name"
+	NodeTagsHeader    = "Tailscale-Node-Tags"
+)
+
+// WhoIser looks up the tailnet identity behind a remote address.
+// *local.Client satisfies it.
+type WhoIser interface {
+	WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+}
+
+// Listener opens listeners on the tailnet. *tsnet.Server satisfies it.
+type Listener interface {
+	Listen(network, addr string) (net.Listener, error)
+}
+
+// Handler returns a reverse proxy to upstream that adds the identity of
+// the tailnet caller to each request. Requests whose caller cannot be
+// identified are rejected.
+func Handler(upstream *url.URL, who WhoIser) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+			r.SetXForwarded()
+			r.Out.Host = r.In.Host
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{UserLoginHeader, UserNameHeader, UserPictureHeader, NodeNameHeader, NodeTagsHeader} {
+			r.Header.Del(h)
+		}
+
+		res, err := who.WhoIs(r.Context(), r.RemoteAddr)
+		if err != nil {
+			util.GetLogger().Debug("WhoIs failed for %s: %s", r.RemoteAddr, err)
+			http.Error(w, "unknown tailnet caller", http.StatusForbidden)
+			return
+		}
+
+		// Tagged nodes have no user; the profile is a placeholder.
+		if res.Node != nil && res.Node.IsTagged() {
+			r.Header.Set(NodeTagsHeader, strings.Join(res.Node.Tags, ","))
+		} else if res.UserProfile != nil {
+			r.Header.Set(UserLoginHeader, res.UserProfile.LoginName)
+			r.Header.Set(UserNameHeader, res.UserProfile.DisplayName)
+			r.Header.Set(UserPictureHeader, res.UserProfile.ProfilePicURL)
+		}
+
+		if res.Node != nil {
+			r.Header.Set(NodeNameHeader, strings.TrimSuffix(res.Node.Name, "."))
+		}
+
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// Start serves h on the tailnet on httpAddr and, if a certificate is
+// given, on tlsAddr. Empty addresses are skipped.
+func Start(ln Listener, h http.Handler, httpAddr, tlsAddr, certFile, keyFile string) error {
+	log := util.GetLogger()
+
+	if httpAddr != "" {
+		l, err := ln.Listen("tcp", httpAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", httpAddr, err)
+		}
+
+		go func() {
+			log.Error("Tailnet HTTP server stopped: %s", http.Serve(l, h))
+		}()
+
+		log.Info("Serving Headplane on the tailnet at http://%s", httpAddr)
+	}
+
+	if tlsAddr != "" {
+		// Load the pair up front so a bad certificate fails at startup.
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+
+		l, err := ln.Listen("tcp", tlsAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", tlsAddr, err)
+		}
+
+		srv := &http.Server{Handler: h}
+		go func() {
+			log.Error("Tailnet HTTPS server stopped: %s", srv.ServeTLS(l, certFile, keyFile))
+		}()
+
+		log.Info("Serving Headplane on the tailnet at https://%s", tlsAddr)
+	}
+
+	return nil
+}
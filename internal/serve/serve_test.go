@@ -0,0 +1,163 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// fakeWhoIs answers every lookup with res, or err if set.
+type fakeWhoIs struct {
+	res *apitype.WhoIsResponse
+	err error
+}
+
+func (f fakeWhoIs) WhoIs(context.Context, string) (*apitype.WhoIsResponse, error) {
+	return f.res, f.err
+}
+
+func TestHandler(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	u, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		who        fakeWhoIs
+		sent       map[string]string
+		wantStatus int
+		want       map[string]string
+	}{
+		{
+			name: "user",
+			who: fakeWhoIs{res: &apitype.WhoIsResponse{
+				Node:        &tailcfg.Node{Name: "laptop.example.ts.net."},
+				UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com", DisplayName: "Alice", ProfilePicURL: "https://example.com/a.png"},
+			}},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				UserLoginHeader:   "alice@example.com",
+				UserNameHeader:    "Alice",
+				UserPictureHeader: "https://example.com/a.png",
+				NodeNameHeader:    "laptop.example.ts.net",
+				NodeTagsHeader:    "",
+			},
+		},
+		{
+			name: "tagged node",
+			who: fakeWhoIs{res: &apitype.WhoIsResponse{
+				Node:        &tailcfg.Node{Name: "ci.example.ts.net.", Tags: []string{"tag:ci", "tag:server"}},
+				UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+			}},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				UserLoginHeader: "",
+				NodeNameHeader:  "ci.example.ts.net",
+				NodeTagsHeader:  "tag:ci,tag:server",
+			},
+		},
+		{
+			name: "spoofed headers are removed",
+			who: fakeWhoIs{res: &apitype.WhoIsResponse{
+				Node:        &tailcfg.Node{Name: "laptop.example.ts.net."},
+				UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+			}},
+			sent:       map[string]string{UserLoginHeader: "admin@example.com", NodeTagsHeader: "tag:admin"},
+			wantStatus: http.StatusOK,
+			want: map[string]string{
+				UserLoginHeader: "alice@example.com",
+				NodeTagsHeader:  "",
+			},
+		},
+		{
+			name:       "unknown caller",
+			who:        fakeWhoIs{err: errors.New("no match")},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodGet, "http://headplane.example.ts.net/admin", nil)
+			for k, v := range tt.sent {
+				req.Header.Set(k, v)
+			}
+
+			rec := httptest.NewRecorder()
+			Handler(u, tt.who).ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if got != nil {
+					t.Error("request reached the upstream")
+				}
+
+				return
+			}
+
+			for k, v := range tt.want {
+				if got.Get(k) != v {
+					t.Errorf("%s = %q, want %q", k, got.Get(k), v)
+				}
+			}
+		})
+	}
+}
+
+// localListener listens on loopback in place of the tailnet.
+type localListener struct{}
+
+func (localListener) Listen(network, _ string) (net.Listener, error) {
+	return net.Listen(network, "127.0.0.1:0")
+}
+
+func TestStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		httpAddr string
+		tlsAddr  string
+		wantErr  string
+	}{
+		{name: "nothing"},
+		{name: "http", httpAddr: ":80"},
+		{name: "missing certificate", tlsAddr: ":443", wantErr: "failed to load certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := Start(localListener{}, http.NotFoundHandler(), tt.httpAddr, tt.tlsAddr,
+				filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Start: %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Start error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
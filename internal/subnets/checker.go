@@ -0,0 +1,349 @@
+package subnets
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/metrics"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/netmap"
+)
+
+// Dialer opens connections over the tailnet. *tsnet.Server satisfies it.
+type Dialer interface {
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Pinger pings a node over the tailnet. *tsnet.TSAgent satisfies it.
+type Pinger interface {
+	Ping(ctx context.Context, ip netip.Addr) (*ipnstate.PingResult, error)
+}
+
+// Router statuses. Traffic to a prefix only flows through its primary
+// router, so only the primary's forwarding is tested by dialing the target.
+// Other routers advertising the prefix are pinged instead and reported as
+// standby while they answer, which says nothing about whether they forward.
+// A target pinned to a router that is not the primary is unverifiable.
+const (
+	StatusOK           = "ok"
+	StatusUnreachable  = "unreachable"
+	StatusStandby      = "standby"
+	StatusUnverifiable = "unverifiable"
+	StatusUnapproved   = "unapproved"
+	StatusOffline      = "offline"
+)
+
+// RouterStatus is the state of one router for a prefix.
+type RouterStatus struct {
+	Node      string  `json:"node"`
+	Name      string  `json:"name"`
+	Online    bool    `json:"online"`
+	Approved  bool    `json:"approved"`
+	Primary   bool    `json:"primary"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// PrefixReport is the result of the latest check of a target.
+type PrefixReport struct {
+	Prefix    netip.Prefix   `json:"prefix"`
+	Target    netip.AddrPort `json:"target"`
+	Primary   string         `json:"primary,omitempty"`
+	Routers   []RouterStatus `json:"routers"`
+	CheckedAt time.Time      `json:"checkedAt"`
+}
+
+// router is a node advertising subnet routes in the latest netmap.
+type router struct {
+	id       string
+	name     string
+	host     string
+	addr     netip.Addr
+	online   bool
+	routes   []netip.Prefix
+	approved []netip.Prefix
+	primary  []netip.Prefix
+}
+
+// Checker periodically checks every router advertising a target's prefix
+// and reports each one separately.
+type Checker struct {
+	cfg  *Config
+	dial Dialer
+	ping Pinger
+
+	// ready is closed by the first netmap, before which there are no
+	// routers to check.
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu      sync.Mutex
+	routers []router
+	reports []PrefixReport
+}
+
+// NewChecker returns a checker that dials through d and pings standby
+// routers with p.
+func NewChecker(cfg *Config, d Dialer, p Pinger) *Checker {
+	return &Checker{cfg: cfg, dial: d, ping: p, ready: make(chan struct{})}
+}
+
+// Observe updates the known subnet routers from a netmap.
+func (c *Checker) Observe(nm *netmap.NetworkMap) {
+	var routers []router
+	for _, n := range nm.Peers {
+		if !n.Valid() || !n.Hostinfo().Valid() {
+			continue
+		}
+
+		r := router{
+			id:     string(n.StableID()),
+			name:   strings.TrimSuffix(n.Name(), "."),
+			host:   n.Hostinfo().Hostname(),
+			online: n.Online().Get(),
+		}
+
+		if addrs := n.Addresses(); addrs.Len() > 0 {
+			r.addr = addrs.At(0).Addr()
+		}
+
+		for _, p := range n.Hostinfo().RoutableIPs().All() {
+			if !tsaddr.IsExitRoute(p) {
+				r.routes = append(r.routes, p.Masked())
+			}
+		}
+
+		if len(r.routes) == 0 {
+			continue
+		}
+
+		for _, p := range n.AllowedIPs().All() {
+			r.approved = append(r.approved, p.Masked())
+		}
+
+		for _, p := range n.PrimaryRoutes().All() {
+			r.primary = append(r.primary, p.Masked())
+		}
+
+		routers = append(routers, r)
+	}
+
+	c.mu.Lock()
+	c.routers = routers
+	c.mu.Unlock()
+
+	c.readyOnce.Do(func() { close(c.ready) })
+}
+
+// Run checks every target on the configured interval until ctx is done.
+// The first check waits for the first netmap so that it has routers to
+// check.
+func (c *Checker) Run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-c.ready:
+	}
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.CheckAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every target once.
+func (c *Checker) CheckAll(ctx context.Context) {
+	c.mu.Lock()
+	routers := c.routers
+	c.mu.Unlock()
+
+	const maxParallel = 8
+	sema := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	reports := make([]PrefixReport, len(c.cfg.Targets))
+	for i, t := range c.cfg.Targets {
+		wg.Add(1)
+		sema <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sema }()
+			reports[i] = c.check(ctx, t, routers)
+		}()
+	}
+
+	wg.Wait()
+
+	metrics.SubnetRouteUp.Init()
+	for _, r := range reports {
+		for _, rs := range r.Routers {
+			if !rs.Primary || (rs.Status != StatusOK && rs.Status != StatusUnreachable) {
+				continue
+			}
+
+			up := int64(0)
+			if rs.Status == StatusOK {
+				up = 1
+			}
+
+			metrics.SubnetRouteUp.SetInt(metrics.SubnetLabels{Prefix: r.Prefix.String(), Router: rs.Name}, up)
+		}
+	}
+
+	c.mu.Lock()
+	c.reports = reports
+	c.mu.Unlock()
+}
+
+// Reports returns the results of the latest run.
+func (c *Checker) Reports() []PrefixReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.reports)
+}
+
+// check reports on every router advertising the target's prefix. The
+// tailnet only sends traffic for a prefix through its primary router, so
+// the target is dialed through that router and the others are pinged to
+// show whether they could take over. A target that names a router which is
+// not the primary can't be dialed through it, so that router is reported as
+// unverifiable rather than pinged.
+func (c *Checker) check(ctx context.Context, t Target, routers []router) PrefixReport {
+	report := PrefixReport{
+		Prefix:    t.Prefix.Masked(),
+		Target:    t.Addr,
+		Routers:   []RouterStatus{},
+		CheckedAt: time.Now(),
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range routers {
+		if !slices.Contains(r.routes, report.Prefix) || !r.matches(t.Router) {
+			continue
+		}
+
+		rs := RouterStatus{
+			Node:     r.id,
+			Name:     r.name,
+			Online:   r.online,
+			Approved: slices.Contains(r.approved, report.Prefix),
+			Primary:  slices.Contains(r.primary, report.Prefix),
+		}
+
+		switch {
+		case !rs.Approved:
+			rs.Status = StatusUnapproved
+		case !rs.Online:
+			rs.Status = StatusOffline
+		case !rs.Primary && t.Router != "":
+			rs.Status = StatusUnverifiable
+			rs.Error = "not the primary router, so its forwarding can't be tested"
+		}
+
+		if rs.Primary {
+			report.Primary = rs.Name
+		}
+
+		report.Routers = append(report.Routers, rs)
+	}
+
+	slices.SortFunc(report.Routers, func(a, b RouterStatus) int { return strings.Compare(a.Name, b.Name) })
+	for i := range report.Routers {
+		rs := &report.Routers[i]
+		if rs.Status != "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rs.Primary {
+				c.dialTarget(ctx, t, rs)
+			} else {
+				c.pingRouter(ctx, routerByID(routers, rs.Node), rs)
+			}
+		}()
+	}
+
+	wg.Wait()
+	return report
+}
+
+// dialTarget dials the target through the primary router.
+func (c *Checker) dialTarget(ctx context.Context, t Target, rs *RouterStatus) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := c.dial.Dial(ctx, "tcp", t.Addr.String())
+	if err != nil {
+		util.GetLogger().Debug("Subnet target %s via %s failed: %s", t.Addr, rs.Name, err)
+		rs.Status = StatusUnreachable
+		rs.Error = err.Error()
+		return
+	}
+
+	conn.Close()
+	rs.Status = StatusOK
+	rs.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+}
+
+// pingRouter checks that a standby router answers over the tailnet.
+func (c *Checker) pingRouter(ctx context.Context, r router, rs *RouterStatus) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	res, err := c.ping.Ping(ctx, r.addr)
+	if err == nil && res.Err != "" {
+		err = errors.New(res.Err)
+	}
+
+	if err != nil {
+		util.GetLogger().Debug("Standby subnet router %s did not answer: %s", rs.Name, err)
+		rs.Status = StatusUnreachable
+		rs.Error = err.Error()
+		return
+	}
+
+	rs.Status = StatusStandby
+	rs.LatencyMs = res.LatencySeconds * 1000
+}
+
+func routerByID(routers []router, id string) router {
+	for _, r := range routers {
+		if r.id == id {
+			return r
+		}
+	}
+
+	return router{}
+}
+
+// matches reports whether name selects r. An empty name selects every
+// router.
+func (r router) matches(name string) bool {
+	if name == "" {
+		return true
+	}
+
+	short, _, _ := strings.Cut(r.name, ".")
+	return strings.EqualFold(name, r.name) || strings.EqualFold(name, short) || strings.EqualFold(name, r.host)
+}
@@ -0,0 +1,238 @@
+package subnets
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// fakeDialer succeeds for addresses in up and fails for everything else.
+type fakeDialer struct {
+	up map[string]bool
+}
+
+func (d fakeDialer) Dial(_ context.Context, _, addr string) (net.Conn, error) {
+	if !d.up[addr] {
+		return nil, errors.New("connection refused")
+	}
+
+	c1, c2 := net.Pipe()
+	c2.Close()
+	return c1, nil
+}
+
+// fakePinger answers pings to addresses in up.
+type fakePinger struct {
+	up map[netip.Addr]bool
+}
+
+func (p fakePinger) Ping(_ context.Context, ip netip.Addr) (*ipnstate.PingResult, error) {
+	if !p.up[ip] {
+		return &ipnstate.PingResult{Err: "timeout"}, nil
+	}
+
+	return &ipnstate.PingResult{LatencySeconds: 0.01}, nil
+}
+
+type testRouter struct {
+	id       string
+	addr     string
+	online   bool
+	approved bool
+	primary  bool
+}
+
+func routerNetMap(prefix netip.Prefix, routers ...testRouter) *netmap.NetworkMap {
+	nm := &netmap.NetworkMap{}
+	for _, r := range routers {
+		addr := netip.MustParsePrefix(r.addr + "/32")
+		n := &tailcfg.Node{
+			StableID:   tailcfg.StableNodeID(r.id),
+			Name:       r.id + ".ts.net.",
+			Online:     &r.online,
+			Addresses:  []netip.Prefix{addr},
+			AllowedIPs: []netip.Prefix{addr},
+			Hostinfo:   (&tailcfg.Hostinfo{Hostname: r.id, RoutableIPs: []netip.Prefix{prefix}}).View(),
+		}
+
+		if r.approved {
+			n.AllowedIPs = append(n.AllowedIPs, prefix)
+		}
+
+		if r.primary {
+			n.PrimaryRoutes = []netip.Prefix{prefix}
+		}
+
+		nm.Peers = append(nm.Peers, n.View())
+	}
+
+	return nm
+}
+
+func TestCheck(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+	target := Target{Prefix: prefix, Addr: netip.MustParseAddrPort("10.0.0.1:22")}
+
+	tests := []struct {
+		name        string
+		target      Target
+		routers     []testRouter
+		targetUp    bool
+		pingUp      []string
+		wantPrimary string
+		want        map[string]string
+	}{
+		{
+			name:   "primary forwards and standby answers",
+			target: target,
+			routers: []testRouter{
+				{id: "gw1", addr: "100.64.0.1", online: true, approved: true, primary: true},
+				{id: "gw2", addr: "100.64.0.2", online: true, approved: true},
+			},
+			targetUp:    true,
+			pingUp:      []string{"100.64.0.2"},
+			wantPrimary: "gw1.ts.net",
+			want:        map[string]string{"gw1.ts.net": StatusOK, "gw2.ts.net": StatusStandby},
+		},
+		{
+			name:   "primary fails and standby is down",
+			target: target,
+			routers: []testRouter{
+				{id: "gw1", addr: "100.64.0.1", online: true, approved: true, primary: true},
+				{id: "gw2", addr: "100.64.0.2", online: true, approved: true},
+			},
+			wantPrimary: "gw1.ts.net",
+			want:        map[string]string{"gw1.ts.net": StatusUnreachable, "gw2.ts.net": StatusUnreachable},
+		},
+		{
+			name:   "unapproved and offline routers",
+			target: target,
+			routers: []testRouter{
+				{id: "gw1", addr: "100.64.0.1", online: true},
+				{id: "gw2", addr: "100.64.0.2", approved: true},
+			},
+			want: map[string]string{"gw1.ts.net": StatusUnapproved, "gw2.ts.net": StatusOffline},
+		},
+		{
+			name:   "router filter names the primary",
+			target: Target{Router: "gw1", Prefix: prefix, Addr: target.Addr},
+			routers: []testRouter{
+				{id: "gw1", addr: "100.64.0.1", online: true, approved: true, primary: true},
+				{id: "gw2", addr: "100.64.0.2", online: true, approved: true},
+			},
+			targetUp:    true,
+			wantPrimary: "gw1.ts.net",
+			want:        map[string]string{"gw1.ts.net": StatusOK},
+		},
+		{
+			name:   "router filter names a standby",
+			target: Target{Router: "gw2", Prefix: prefix, Addr: target.Addr},
+			routers: []testRouter{
+				{id: "gw1", addr: "100.64.0.1", online: true, approved: true, primary: true},
+				{id: "gw2", addr: "100.64.0.2", online: true, approved: true},
+			},
+			targetUp: true,
+			pingUp:   []string{"100.64.0.2"},
+			want:     map[string]string{"gw2.ts.net": StatusUnverifiable},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := fakeDialer{up: map[string]bool{tt.target.Addr.String(): tt.targetUp}}
+			p := fakePinger{up: map[netip.Addr]bool{}}
+			for _, a := range tt.pingUp {
+				p.up[netip.MustParseAddr(a)] = true
+			}
+
+			cfg := &Config{Targets: []Target{tt.target}, Interval: time.Minute, Timeout: time.Second}
+			c := NewChecker(cfg, d, p)
+			c.Observe(routerNetMap(prefix, tt.routers...))
+			c.CheckAll(context.Background())
+
+			reports := c.Reports()
+			if len(reports) != 1 {
+				t.Fatalf("got %d reports, want 1", len(reports))
+			}
+
+			if reports[0].Primary != tt.wantPrimary {
+				t.Errorf("Primary = %q, want %q", reports[0].Primary, tt.wantPrimary)
+			}
+
+			got := map[string]string{}
+			for _, rs := range reports[0].Routers {
+				got[rs.Name] = rs.Status
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statuses = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckerWaitsForNetMap(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+	target := Target{Prefix: prefix, Addr: netip.MustParseAddrPort("10.0.0.1:22")}
+	nm := routerNetMap(prefix, testRouter{id: "gw1", addr: "100.64.0.1", online: true, approved: true, primary: true})
+
+	tests := []struct {
+		name string
+		// observe delivers the netmap after Run has started.
+		observe bool
+		want    map[string]string
+	}{
+		{name: "first check follows the first netmap", observe: true, want: map[string]string{"gw1.ts.net": StatusOK}},
+		{name: "stops while waiting for a netmap"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The interval is far longer than the test, so a report can only
+			// come from the first check.
+			cfg := &Config{Targets: []Target{target}, Interval: time.Hour, Timeout: time.Second}
+			d := fakeDialer{up: map[string]bool{target.Addr.String(): true}}
+			c := NewChecker(cfg, d, fakePinger{})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				c.Run(ctx)
+			}()
+
+			if tt.observe {
+				time.Sleep(50 * time.Millisecond)
+				c.Observe(nm)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for tt.observe && len(c.Reports()) == 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			cancel()
+			<-done
+
+			var got map[string]string
+			for _, r := range c.Reports() {
+				got = map[string]string{}
+				for _, rs := range r.Routers {
+					got[rs.Name] = rs.Status
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statuses = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
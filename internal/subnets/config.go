@@ -0,0 +1,78 @@
+package subnets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// Target is an address inside an advertised route that should answer when
+// forwarding through the router works. Router optionally limits the target
+// to one router by MagicDNS name or hostname; otherwise it applies to every
+// router advertising the prefix.
+type Target struct {
+	Router string         `json:"router,omitempty"`
+	Prefix netip.Prefix   `json:"prefix"`
+	Addr   netip.AddrPort `json:"target"`
+}
+
+// Config lists the subnet targets to dial and how often.
+type Config struct {
+	Targets  []Target
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Load reads and validates a subnet verification config from a JSON file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subnet targets: %w", err)
+	}
+
+	var raw struct {
+		Targets  []Target `json:"targets"`
+		Interval string   `json:"interval"`
+		Timeout  string   `json:"timeout"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse subnet targets: %w", err)
+	}
+
+	c := &Config{
+		Targets:  raw.Targets,
+		Interval: 5 * time.Minute,
+		Timeout:  5 * time.Second,
+	}
+
+	for _, t := range c.Targets {
+		if !t.Prefix.Contains(t.Addr.Addr()) {
+			return nil, fmt.Errorf("target %s is not inside %s", t.Addr, t.Prefix)
+		}
+	}
+
+	if raw.Interval != "" {
+		if c.Interval, err = time.ParseDuration(raw.Interval); err != nil {
+			return nil, fmt.Errorf("invalid interval: %w", err)
+		}
+
+		if c.Interval <= 0 {
+			return nil, fmt.Errorf("invalid interval: %s is not positive", raw.Interval)
+		}
+	}
+
+	if raw.Timeout != "" {
+		if c.Timeout, err = time.ParseDuration(raw.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+
+		if c.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout: %s is not positive", raw.Timeout)
+		}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,77 @@
+package subnets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		wantInterval time.Duration
+		wantTimeout  time.Duration
+		wantErr      string
+	}{
+		{
+			name:         "defaults",
+			config:       `{"targets":[{"prefix":"10.0.0.0/24","target":"10.0.0.1:22"}]}`,
+			wantInterval: 5 * time.Minute,
+			wantTimeout:  5 * time.Second,
+		},
+		{
+			name:         "explicit durations",
+			config:       `{"targets":[],"interval":"1m","timeout":"2s"}`,
+			wantInterval: time.Minute,
+			wantTimeout:  2 * time.Second,
+		},
+		{
+			name:    "target outside prefix",
+			config:  `{"targets":[{"prefix":"10.0.0.0/24","target":"10.0.1.1:22"}]}`,
+			wantErr: "is not inside",
+		},
+		{
+			name:    "zero interval",
+			config:  `{"interval":"0s"}`,
+			wantErr: "invalid interval",
+		},
+		{
+			name:    "negative interval",
+			config:  `{"interval":"-5m"}`,
+			wantErr: "invalid interval",
+		},
+		{
+			name:    "zero timeout",
+			config:  `{"timeout":"0s"}`,
+			wantErr: "invalid timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "subnets.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := Load(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if c.Interval != tt.wantInterval || c.Timeout != tt.wantTimeout {
+				t.Errorf("got interval %s, timeout %s, want %s, %s", c.Interval, c.Timeout, tt.wantInterval, tt.wantTimeout)
+			}
+		})
+	}
+}
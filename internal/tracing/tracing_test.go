@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is an OTLP/HTTP stand-in that keeps every span it receives.
+type collector struct {
+	mu    sync.Mutex
+	paths []string
+	spans []*tracepb.Span
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, r.URL.Path)
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	resp, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Write(resp)
+}
+
+func TestExport(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name        string
+		path        string
+		traceparent string
+		wantPath    string
+		wantTrace   string
+		wantParent  string
+	}{
+		{name: "new trace", wantPath: "/v1/traces"},
+		{name: "custom path", path: "/otlp/v1/traces", wantPath: "/otlp/v1/traces"},
+		{
+			name:        "joins headplane trace",
+			traceparent: "00-" + traceID + "-" + spanID + "-01",
+			wantPath:    "/v1/traces",
+			wantTrace:   traceID,
+			wantParent:  spanID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := new(collector)
+			srv := httptest.NewServer(c)
+			defer srv.Close()
+
+			shutdown, err := Init(context.Background(), srv.URL+tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := Extract(context.Background(), tt.traceparent, "")
+			ctx, parent := Start(ctx, "agent.sync")
+			_, child := Start(ctx, "tailscale.whois")
+			End(child, io.EOF)
+			End(parent, nil)
+
+			if err := shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if len(c.paths) == 0 || c.paths[0] != tt.wantPath {
+				t.Fatalf("export paths = %v, want %s", c.paths, tt.wantPath)
+			}
+
+			spans := make(map[string]*tracepb.Span)
+			for _, s := range c.spans {
+				spans[s.Name] = s
+			}
+
+			sync, whois := spans["agent.sync"], spans["tailscale.whois"]
+			if sync == nil || whois == nil {
+				t.Fatalf("exported spans = %v, want agent.sync and tailscale.whois", c.spans)
+			}
+
+			if hex.EncodeToString(whois.ParentSpanId) != hex.EncodeToString(sync.SpanId) {
+				t.Error("tailscale.whois is not a child of agent.sync")
+			}
+
+			if whois.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR {
+				t.Errorf("tailscale.whois status = %v, want error", whois.Status.GetCode())
+			}
+
+			if tt.wantTrace != "" {
+				if got := hex.EncodeToString(sync.TraceId); got != tt.wantTrace {
+					t.Errorf("trace ID = %s, want %s", got, tt.wantTrace)
+				}
+
+				if got := hex.EncodeToString(sync.ParentSpanId); got != tt.wantParent {
+					t.Errorf("parent span ID = %s, want %s", got, tt.wantParent)
+				}
+			} else if len(sync.ParentSpanId) != 0 {
+				t.Errorf("agent.sync has parent %x, want a root span", sync.ParentSpanId)
+			}
+		})
+	}
+}
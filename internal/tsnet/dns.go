@@ -0,0 +1,359 @@
+package tsnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/util"
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// NameCheck is the result of resolving a node's MagicDNS name.
+type NameCheck struct {
+	Node     string   `json:"node"`
+	Name     string   `json:"name"`
+	Expected []string `json:"expected"`
+	Resolved []string `json:"resolved"`
+	Status   string   `json:"status"` // ok, partial, unresolved or mismatch
+	Error    string   `json:"error,omitempty"`
+}
+
+// NameCollision lists nodes that were assigned the same MagicDNS name.
+type NameCollision struct {
+	Name  string   `json:"name"`
+	Nodes []string `json:"nodes"`
+}
+
+// UpstreamCheck is the result of querying a split-DNS upstream directly.
+type UpstreamCheck struct {
+	Domain   string `json:"domain"`
+	Resolver string `json:"resolver"`
+	Status   string `json:"status"` // ok, unreachable or unsupported
+	Error    string `json:"error,omitempty"`
+}
+
+// DNSReport describes whether the DNS configuration pushed by control works.
+type DNSReport struct {
+	MagicDNS   bool            `json:"magicDNS"`
+	Domains    []string        `json:"domains"`
+	Names      []NameCheck     `json:"names"`
+	Collisions []NameCollision `json:"collisions"`
+	SplitDNS   []UpstreamCheck `json:"splitDNS"`
+}
+
+const dnsTimeout = 3 * time.Second
+
+// VerifyDNS resolves every node's MagicDNS name through the tailnet resolver
+// and queries each split-DNS upstream from the current netmap.
+func (s *TSAgent) VerifyDNS(ctx context.Context) (*DNSReport, error) {
+	nm, err := s.NetMap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DNSReport{
+		MagicDNS:   nm.DNS.Proxied,
+		Domains:    nm.DNS.Domains,
+		Names:      []NameCheck{},
+		Collisions: []NameCollision{},
+		SplitDNS:   []UpstreamCheck{},
+	}
+
+	byName := make(map[string][]string)
+	for _, n := range slices.Concat(nm.Peers, []tailcfg.NodeView{nm.SelfNode}) {
+		if !n.Valid() {
+			continue
+		}
+
+		name := strings.TrimSuffix(n.Name(), ".")
+		if name == "" {
+			continue
+		}
+
+		var expected []string
+		for _, p := range n.Addresses().All() {
+			expected = append(expected, p.Addr().String())
+		}
+
+		sort.Strings(expected)
+		report.Names = append(report.Names, NameCheck{
+			Node:     n.Key().String(),
+			Name:     name,
+			Expected: expected,
+		})
+
+		lower := strings.ToLower(name)
+		byName[lower] = append(byName[lower], n.Key().String())
+	}
+
+	for name, ids := range byName {
+		if len(ids) > 1 {
+			sort.Strings(ids)
+			report.Collisions = append(report.Collisions, NameCollision{Name: name, Nodes: ids})
+		}
+	}
+
+	const maxParallel = 8
+	sema := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for i := range report.Names {
+		wg.Add(1)
+		sema <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sema }()
+			s.checkName(ctx, &report.Names[i])
+		}()
+	}
+
+	for domain, resolvers := range nm.DNS.Routes {
+		for _, r := range resolvers {
+			report.SplitDNS = append(report.SplitDNS, UpstreamCheck{
+				Domain:   strings.TrimSuffix(domain, "."),
+				Resolver: r.Addr,
+			})
+		}
+	}
+
+	for i := range report.SplitDNS {
+		wg.Add(1)
+		sema <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sema }()
+			s.checkUpstream(ctx, nm, &report.SplitDNS[i])
+		}()
+	}
+
+	wg.Wait()
+
+	slices.SortFunc(report.Names, func(a, b NameCheck) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(report.Collisions, func(a, b NameCollision) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(report.SplitDNS, func(a, b UpstreamCheck) int {
+		if c := strings.Compare(a.Domain, b.Domain); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.Resolver, b.Resolver)
+	})
+
+	return report, nil
+}
+
+// checkName resolves the A and AAAA records for a node through the local
+// Tailscale resolver and compares them with its assigned addresses.
+func (s *TSAgent) checkName(ctx context.Context, c *NameCheck) {
+	log := util.GetLogger()
+	c.Resolved = []string{}
+	failed := false
+
+	for _, qtype := range []string{"A", "AAAA"} {
+		qctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+		raw, _, err := s.Lc.QueryDNS(qctx, c.Name+".", qtype)
+		cancel()
+
+		if err != nil {
+			log.Debug("DNS query for %s (%s) failed: %s", c.Name, qtype, err)
+			c.Error = fmt.Sprintf("%s query: %s", qtype, err)
+			failed = true
+			continue
+		}
+
+		addrs, err := parseAnswers(raw)
+		if err != nil {
+			log.Debug("Failed to parse DNS response for %s: %s", c.Name, err)
+			c.Error = fmt.Sprintf("%s query: %s", qtype, err)
+			failed = true
+			continue
+		}
+
+		for _, a := range addrs {
+			c.Resolved = append(c.Resolved, a.String())
+		}
+	}
+
+	sort.Strings(c.Resolved)
+	c.Status = nameStatus(c.Resolved, c.Expected, failed)
+	if c.Status == "ok" {
+		c.Error = ""
+	}
+}
+
+// nameStatus classifies a lookup. When one of the queries failed the
+// answer is incomplete, so it is reported as partial rather than compared.
+func nameStatus(resolved, expected []string, failed bool) string {
+	switch {
+	case len(resolved) == 0:
+		return "unresolved"
+	case failed:
+		return "partial"
+	case !slices.Equal(resolved, expected):
+		return "mismatch"
+	default:
+		return "ok"
+	}
+}
+
+// checkUpstream sends an SOA query for the routed domain straight to the
+// upstream resolver. Any well-formed response counts as an answer.
+// Resolvers inside the tailnet are reached through tsnet, and any other
+// resolver through the host network.
+func (s *TSAgent) checkUpstream(ctx context.Context, nm *netmap.NetworkMap, c *UpstreamCheck) {
+	addr, ok := resolverAddr(c.Resolver)
+	if !ok {
+		c.Status = "unsupported"
+		return
+	}
+
+	dial := new(net.Dialer).DialContext
+	if inTailnet(nm, addr.Addr()) {
+		dial = s.Dial
+	}
+
+	qctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+
+	if err := exchange(qctx, dial, addr, c.Domain+"."); err != nil {
+		c.Status = "unreachable"
+		c.Error = err.Error()
+		return
+	}
+
+	c.Status = "ok"
+}
+
+// inTailnet reports whether ip is a Tailscale address or inside a route a
+// peer serves for the agent.
+func inTailnet(nm *netmap.NetworkMap, ip netip.Addr) bool {
+	if tsaddr.IsTailscaleIP(ip) {
+		return true
+	}
+
+	for _, p := range nm.Peers {
+		for _, r := range p.PrimaryRoutes().All() {
+			if r.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// resolverAddr returns the UDP address of a plain DNS resolver. DoH and
+// other transports are not probed.
+func resolverAddr(addr string) (netip.AddrPort, bool) {
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		return netip.AddrPortFrom(ip, 53), true
+	}
+
+	ap, err := netip.ParseAddrPort(addr)
+	return ap, err == nil
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// exchange sends an SOA query for domain to addr over UDP and waits for a
+// well-formed response that isn't SERVFAIL or REFUSED.
+func exchange(ctx context.Context, dial dialFunc, addr netip.AddrPort, domain string) error {
+	name, err := dnsmessage.NewName(domain)
+	if err != nil {
+		return err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET})
+	query, err := b.Finish()
+	if err != nil {
+		return err
+	}
+
+	conn, err := dial(ctx, "udp", addr.String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1232)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	var p dnsmessage.Parser
+	h, err := p.Start(buf[:n])
+	if err != nil {
+		return err
+	}
+
+	if h.RCode == dnsmessage.RCodeServerFailure || h.RCode == dnsmessage.RCodeRefused {
+		return fmt.Errorf("upstream returned %s", h.RCode)
+	}
+
+	return nil
+}
+
+// parseAnswers extracts the A and AAAA records from a raw DNS response.
+func parseAnswers(raw []byte) ([]netip.Addr, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(raw); err != nil {
+		return nil, err
+	}
+
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	var out []netip.Addr
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return out, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch h.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, netip.AddrFrom4(r.A))
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, netip.AddrFrom16(r.AAAA))
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
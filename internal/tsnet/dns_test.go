@@ -0,0 +1,185 @@
+package tsnet
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestNameStatus(t *testing.T) {
+	expected := []string{"100.64.0.1", "fd7a:115c:a1e0::1"}
+	tests := []struct {
+		name     string
+		resolved []string
+		failed   bool
+		want     string
+	}{
+		{name: "ok", resolved: expected, want: "ok"},
+		{name: "nothing resolved", resolved: nil, want: "unresolved"},
+		{name: "nothing resolved after failure", resolved: nil, failed: true, want: "unresolved"},
+		{name: "one query failed", resolved: expected[:1], failed: true, want: "partial"},
+		{name: "wrong address", resolved: []string{"100.64.0.2", "fd7a:115c:a1e0::1"}, want: "mismatch"},
+		{name: "missing address", resolved: expected[:1], want: "mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nameStatus(tt.resolved, expected, tt.failed); got != tt.want {
+				t.Errorf("nameStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAnswers(t *testing.T) {
+	name := dnsmessage.MustNewName("node.tailnet.example.com.")
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	b.StartAnswers()
+	hdr := dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET}
+	b.AResource(hdr, dnsmessage.AResource{A: [4]byte{100, 64, 0, 1}})
+	b.CNAMEResource(hdr, dnsmessage.CNAMEResource{CNAME: name})
+	b.AAAAResource(hdr, dnsmessage.AAAAResource{AAAA: netip.MustParseAddr("fd7a:115c:a1e0::1").As16()})
+	raw, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseAnswers(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []netip.Addr{netip.MustParseAddr("100.64.0.1"), netip.MustParseAddr("fd7a:115c:a1e0::1")}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseAnswers() = %v, want %v", got, want)
+	}
+
+	if _, err := parseAnswers([]byte{1, 2}); err == nil {
+		t.Error("parseAnswers() accepted a truncated message")
+	}
+}
+
+// serveDNS runs a local DNS stand-in that answers every query with rcode,
+// or never answers when silent is set.
+func serveDNS(t *testing.T, rcode dnsmessage.RCode, silent bool) netip.AddrPort {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if silent {
+				continue
+			}
+
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+
+			q, _ := p.Question()
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RCode: rcode})
+			b.StartQuestions()
+			b.Question(q)
+			resp, _ := b.Finish()
+			conn.WriteTo(resp, from)
+		}
+	}()
+
+	return netip.MustParseAddrPort(conn.LocalAddr().String())
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name    string
+		rcode   dnsmessage.RCode
+		silent  bool
+		wantErr bool
+	}{
+		{name: "answer", rcode: dnsmessage.RCodeSuccess},
+		{name: "nxdomain still answers", rcode: dnsmessage.RCodeNameError},
+		{name: "servfail", rcode: dnsmessage.RCodeServerFailure, wantErr: true},
+		{name: "refused", rcode: dnsmessage.RCodeRefused, wantErr: true},
+		{name: "no answer", silent: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serveDNS(t, tt.rcode, tt.silent)
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			err := exchange(ctx, new(net.Dialer).DialContext, addr, "corp.example.com.")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("exchange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInTailnet(t *testing.T) {
+	nm := &netmap.NetworkMap{Peers: []tailcfg.NodeView{
+		(&tailcfg.Node{PrimaryRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}}).View(),
+	}}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "100.100.100.100", want: true},
+		{ip: "100.64.0.5", want: true},
+		{ip: "fd7a:115c:a1e0::5", want: true},
+		{ip: "10.0.0.53", want: true},
+		{ip: "10.0.1.53", want: false},
+		{ip: "1.1.1.1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := inTailnet(nm, netip.MustParseAddr(tt.ip)); got != tt.want {
+				t.Errorf("inTailnet(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolverAddr(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{in: "10.0.0.53", want: "10.0.0.53:53", ok: true},
+		{in: "10.0.0.53:5353", want: "10.0.0.53:5353", ok: true},
+		{in: "[fd00::53]:53", want: "[fd00::53]:53", ok: true},
+		{in: "https://dns.example.com/dns-query", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := resolverAddr(tt.in)
+			if ok != tt.ok || (ok && got.String() != tt.want) {
+				t.Errorf("resolverAddr(%q) = %s, %v, want %s, %v", tt.in, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package tsnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/tracing"
+	"github.com/tale/headplane/internal/util"
+	"go.opentelemetry.io/otel/attribute"
+	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+)
+
+// EgressCheck is the result of fetching the check URL through an exit node.
+type EgressCheck struct {
+	Node       string  `json:"node"`
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	StatusCode int     `json:"statusCode,omitempty"`
+	LatencyMs  float64 `json:"latencyMs,omitempty"`
+	EgressIP   string  `json:"egressIP,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+const (
+	egressTimeout = 15 * time.Second
+
+	// drainTimeout bounds how long a check waits for the agent's other
+	// connections outside the tailnet to close before giving up.
+	drainTimeout = 30 * time.Second
+)
+
+// VerifyExitNodes uses every approved exit node in turn and fetches url
+// through it. The response body is expected to be the caller's IP address
+// in plain text, as returned by services like ifconfig.me.
+//
+// Switching exit nodes would reroute every connection the agent makes
+// outside the tailnet, so new connections are held back and the check
+// waits for open ones to close first. The exit node settings in effect
+// beforehand are restored when it returns or the agent shuts down.
+func (s *TSAgent) VerifyExitNodes(ctx context.Context, url string) ([]EgressCheck, error) {
+	s.exitMu.Lock()
+	defer s.exitMu.Unlock()
+
+	nm, err := s.NetMap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs, err := s.Lc.GetPrefs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prefs: %w", err)
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, drainTimeout)
+	err = s.egress.pause(dctx)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("other connections outside the tailnet are still open: %w", err)
+	}
+	defer s.egress.open()
+
+	var once sync.Once
+	restore := func() {
+		once.Do(func() {
+			_, err := s.Lc.EditPrefs(context.Background(), &ipn.MaskedPrefs{
+				Prefs:         ipn.Prefs{ExitNodeID: prefs.ExitNodeID, ExitNodeIP: prefs.ExitNodeIP},
+				ExitNodeIDSet: true,
+				ExitNodeIPSet: true,
+			})
+
+			if err != nil {
+				util.GetLogger().Error("Failed to restore exit node: %s", err)
+			}
+		})
+	}
+
+	s.mu.Lock()
+	s.restoreExit = restore
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.restoreExit = nil
+		s.mu.Unlock()
+		restore()
+	}()
+
+	return verifyExitNodes(ctx, nm.Peers, s.useExitNode, s.HTTPClient(), url), nil
+}
+
+// useExitNode routes the agent's traffic outside the tailnet through id.
+func (s *TSAgent) useExitNode(ctx context.Context, id tailcfg.StableNodeID) error {
+	_, err := s.Lc.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs:         ipn.Prefs{ExitNodeID: id},
+		ExitNodeIDSet: true,
+		ExitNodeIPSet: true,
+	})
+
+	return err
+}
+
+// verifyExitNodes checks every online peer that is approved as an exit
+// node, switching to it with use and fetching url with client.
+func verifyExitNodes(ctx context.Context, peers []tailcfg.NodeView, use func(context.Context, tailcfg.StableNodeID) error, client *http.Client, url string) []EgressCheck {
+	checks := []EgressCheck{}
+	for _, n := range peers {
+		if !n.Valid() || !tsaddr.ContainsExitRoute(n.AllowedIPs()) {
+			continue
+		}
+
+		check := EgressCheck{Node: string(n.StableID()), Name: strings.TrimSuffix(n.Name(), ".")}
+		if !n.Online().Get() {
+			check.Error = "node is offline"
+		} else {
+			checkEgress(ctx, &check, use, client, url)
+		}
+
+		checks = append(checks, check)
+	}
+
+	slices.SortFunc(checks, func(a, b EgressCheck) int { return strings.Compare(a.Name, b.Name) })
+	return checks
+}
+
+// checkEgress switches to the exit node in c and fetches url through it.
+func checkEgress(ctx context.Context, c *EgressCheck, use func(context.Context, tailcfg.StableNodeID) error, client *http.Client, url string) {
+	log := util.GetLogger()
+
+	ctx, cancel := context.WithTimeout(ctx, egressTimeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "tailscale.exit_node", attribute.String("node.name", c.Name))
+	err := use(ctx, tailcfg.StableNodeID(c.Node))
+	if err != nil {
+		err = fmt.Errorf("failed to set exit node: %w", err)
+	} else {
+		err = fetchEgress(ctx, c, client, url)
+	}
+	tracing.End(span, err)
+
+	if err != nil {
+		log.Debug("Egress check through %s failed: %s", c.Name, err)
+		c.Error = err.Error()
+	}
+}
+
+func fetchEgress(ctx context.Context, c *EgressCheck, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 256))
+	c.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	c.StatusCode = res.StatusCode
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New(res.Status)
+	}
+
+	if ip, err := netip.ParseAddr(strings.TrimSpace(string(body))); err == nil {
+		c.EgressIP = ip.String()
+	}
+
+	c.OK = true
+	return nil
+}
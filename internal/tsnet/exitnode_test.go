@@ -0,0 +1,225 @@
+package tsnet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// egressStandIn answers like ifconfig.me, returning a different egress IP
+// for each exit node the test has switched to.
+type egressStandIn struct {
+	current tailcfg.StableNodeID
+	ips     map[tailcfg.StableNodeID]string
+	used    []tailcfg.StableNodeID
+}
+
+func (e *egressStandIn) use(_ context.Context, id tailcfg.StableNodeID) error {
+	if id == "broken" {
+		return errors.New("no such exit node")
+	}
+
+	e.current = id
+	e.used = append(e.used, id)
+	return nil
+}
+
+func (e *egressStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ip, ok := e.ips[e.current]
+	if !ok {
+		http.Error(w, "blocked", http.StatusForbidden)
+		return
+	}
+
+	io.WriteString(w, ip+"\n")
+}
+
+func exitPeer(id string, online, approved bool) tailcfg.NodeView {
+	n := &tailcfg.Node{
+		StableID:   tailcfg.StableNodeID(id),
+		Name:       id + ".ts.net.",
+		Online:     &online,
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+	}
+
+	if approved {
+		n.AllowedIPs = append(n.AllowedIPs, tsaddr.AllIPv4(), tsaddr.AllIPv6())
+	}
+
+	return n.View()
+}
+
+func TestVerifyExitNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		peers    []tailcfg.NodeView
+		ips      map[tailcfg.StableNodeID]string
+		want     []EgressCheck
+		wantUsed []tailcfg.StableNodeID
+	}{
+		{
+			name:     "reports the egress ip of each exit node",
+			peers:    []tailcfg.NodeView{exitPeer("b", true, true), exitPeer("a", true, true)},
+			ips:      map[tailcfg.StableNodeID]string{"a": "203.0.113.1", "b": "203.0.113.2"},
+			want:     []EgressCheck{{Node: "a", Name: "a.ts.net", OK: true, StatusCode: 200, EgressIP: "203.0.113.1"}, {Node: "b", Name: "b.ts.net", OK: true, StatusCode: 200, EgressIP: "203.0.113.2"}},
+			wantUsed: []tailcfg.StableNodeID{"b", "a"},
+		},
+		{
+			name:     "failed fetch",
+			peers:    []tailcfg.NodeView{exitPeer("a", true, true)},
+			want:     []EgressCheck{{Node: "a", Name: "a.ts.net", StatusCode: 403, Error: "403 Forbidden"}},
+			wantUsed: []tailcfg.StableNodeID{"a"},
+		},
+		{
+			name:  "offline and unapproved nodes are not used",
+			peers: []tailcfg.NodeView{exitPeer("a", false, true), exitPeer("b", true, false)},
+			want:  []EgressCheck{{Node: "a", Name: "a.ts.net", Error: "node is offline"}},
+		},
+		{
+			name:  "exit node cannot be selected",
+			peers: []tailcfg.NodeView{exitPeer("broken", true, true)},
+			want:  []EgressCheck{{Node: "broken", Name: "broken.ts.net", Error: "failed to set exit node: no such exit node"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			standIn := &egressStandIn{ips: tt.ips}
+			srv := httptest.NewServer(standIn)
+			defer srv.Close()
+
+			got := verifyExitNodes(context.Background(), tt.peers, standIn.use, srv.Client(), srv.URL)
+			for i := range got {
+				got[i].LatencyMs = 0
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checks = %+v, want %+v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(standIn.used, tt.wantUsed) {
+				t.Errorf("used exit nodes %v, want %v", standIn.used, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestEgressGate(t *testing.T) {
+	tests := []struct {
+		name string
+		// open is how many connections are open when the gate is paused.
+		open      int
+		closeOpen bool
+		wantPause bool
+	}{
+		{name: "no connections", wantPause: true},
+		{name: "open connections close", open: 2, closeOpen: true, wantPause: true},
+		{name: "open connections stay open", open: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g egressGate
+			for range tt.open {
+				if err := g.enter(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.closeOpen {
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					for range tt.open {
+						g.leave()
+					}
+				}()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			err := g.pause(ctx)
+			if (err == nil) != tt.wantPause {
+				t.Fatalf("pause() error = %v, want paused %v", err, tt.wantPause)
+			}
+
+			if !tt.wantPause {
+				// A failed pause reopens the gate.
+				if err := g.enter(context.Background()); err != nil {
+					t.Errorf("enter() after failed pause = %v", err)
+				}
+				return
+			}
+
+			// New connections wait while the gate is paused.
+			blocked, stop := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer stop()
+			if err := g.enter(blocked); err == nil {
+				t.Error("enter() succeeded while paused")
+			}
+
+			entered := make(chan error)
+			go func() { entered <- g.enter(context.Background()) }()
+			g.open()
+
+			select {
+			case err := <-entered:
+				if err != nil {
+					t.Errorf("enter() after open = %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Error("enter() still blocked after open")
+			}
+		})
+	}
+}
+
+func TestGated(t *testing.T) {
+	var g egressGate
+	g.observe(&netmap.NetworkMap{Peers: []tailcfg.NodeView{
+		(&tailcfg.Node{
+			Name:       "router.example.ts.net.",
+			AllowedIPs: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32"), netip.MustParsePrefix("10.0.0.0/24")},
+		}).View(),
+		(&tailcfg.Node{
+			Name:       "exit.example.ts.net.",
+			AllowedIPs: []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32"), tsaddr.AllIPv4(), tsaddr.AllIPv6()},
+		}).View(),
+	}})
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "100.64.0.1:80"},
+		{addr: "[fd7a:115c:a1e0::1]:443"},
+		{addr: "100.64.0.1"},
+		{addr: "10.0.0.1:22"},
+		{addr: "router.example.ts.net:443"},
+		{addr: "Router.Example.ts.net.:443"},
+		{addr: "router:80"},
+		{addr: "10.0.1.1:22", want: true},
+		{addr: "203.0.113.1:443", want: true},
+		{addr: "example.com:443", want: true},
+		{addr: "other.example.ts.net:443", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.ReplaceAll(tt.addr, ":", "_"), func(t *testing.T) {
+			if got := g.gated(tt.addr); got != tt.want {
+				t.Errorf("gated(%q) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package tsnet
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/netmap"
+)
+
+// egressGate tracks connections the agent makes to destinations outside the
+// tailnet, which are routed through the exit node when one is in use. An
+// exit node check pauses the gate so that no other traffic is rerouted
+// while it switches between exit nodes.
+type egressGate struct {
+	mu     sync.Mutex
+	active int
+
+	// names and routes are the peers' MagicDNS names and subnet routes
+	// from the latest netmap, which never leave through an exit node.
+	names  map[string]bool
+	routes []netip.Prefix
+
+	// resume is non-nil while the gate is paused and closed to resume it.
+	resume chan struct{}
+	// drained is closed when the last active connection closes during a
+	// pause.
+	drained chan struct{}
+}
+
+// observe records the tailnet names and routes in nm, so that connections
+// to them are not held back.
+func (g *egressGate) observe(nm *netmap.NetworkMap) {
+	names := make(map[string]bool)
+	var routes []netip.Prefix
+	for _, n := range nm.Peers {
+		if !n.Valid() {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimSuffix(n.Name(), "."))
+		if name != "" {
+			short, _, _ := strings.Cut(name, ".")
+			names[name] = true
+			names[short] = true
+		}
+
+		for _, p := range n.AllowedIPs().All() {
+			if !tsaddr.IsExitRoute(p) {
+				routes = append(routes, p)
+			}
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.names = names
+	g.routes = routes
+}
+
+// gated reports whether a connection to addr may leave through an exit
+// node, meaning it is not to a Tailscale IP, a peer's subnet route or a
+// peer's MagicDNS name.
+func (g *egressGate) gated(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return !g.names[strings.ToLower(strings.TrimSuffix(host, "."))]
+	}
+
+	if tsaddr.IsTailscaleIP(ip) {
+		return false
+	}
+
+	for _, p := range g.routes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// enter waits until the gate is open and counts a new connection.
+func (g *egressGate) enter(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		if g.resume == nil {
+			g.active++
+			g.mu.Unlock()
+			return nil
+		}
+
+		resume := g.resume
+		g.mu.Unlock()
+
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// leave counts a closed connection.
+func (g *egressGate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.active--
+	if g.active == 0 && g.drained != nil {
+		close(g.drained)
+		g.drained = nil
+	}
+}
+
+// pause stops new connections and waits for active ones to close. The gate
+// is reopened if ctx ends first; otherwise the caller must call open.
+func (g *egressGate) pause(ctx context.Context) error {
+	g.mu.Lock()
+	g.resume = make(chan struct{})
+	if g.active == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+
+	g.drained = make(chan struct{})
+	drained := g.drained
+	g.mu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		g.open()
+		return ctx.Err()
+	}
+}
+
+// open lets connections through again.
+func (g *egressGate) open() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.resume != nil {
+		close(g.resume)
+	}
+
+	g.resume = nil
+	g.drained = nil
+}
+
+// gatedConn leaves the gate when it is closed.
+type gatedConn struct {
+	net.Conn
+	once  sync.Once
+	leave func()
+}
+
+func (c *gatedConn) Close() error {
+	c.once.Do(c.leave)
+	return c.Conn.Close()
+}
+
+// Dial connects to addr over the tailnet. Connections to anything outside
+// the tailnet pass through the egress gate, since they may leave through an
+// exit node.
+func (s *TSAgent) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if !s.egress.gated(addr) {
+		return s.Server.Dial(ctx, network, addr)
+	}
+
+	if err := s.egress.enter(ctx); err != nil {
+		return nil, err
+	}
+
+	conn, err := s.Server.Dial(ctx, network, addr)
+	if err != nil {
+		s.egress.leave()
+		return nil, err
+	}
+
+	return &gatedConn{Conn: conn, leave: s.egress.leave}, nil
+}
@@ -0,0 +1,77 @@
+package tsnet
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/tale/headplane/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Identity is the verified tailnet user and node behind an address. Tagged
+// nodes act on behalf of their tags, so they have no user login.
+type Identity struct {
+	Login         string   `json:"login,omitempty"`
+	DisplayName   string   `json:"displayName,omitempty"`
+	ProfilePicURL string   `json:"profilePicURL,omitempty"`
+	Node          string   `json:"node"`
+	NodeID        string   `json:"nodeID"`
+	NodeKey       string   `json:"nodeKey"`
+	Tags          []string `json:"tags"`
+	Addresses     []string `json:"addresses"`
+}
+
+// Identify looks up who is behind addr, an IP or IP:port on the tailnet.
+func (s *TSAgent) Identify(ctx context.Context, addr string) (*Identity, error) {
+	if _, err := netip.ParseAddrPort(addr); err != nil {
+		if _, err := netip.ParseAddr(addr); err != nil {
+			return nil, errors.New("address must be an IP or IP:port")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "tailscale.whois", attribute.String("node.ip", addr))
+	res, err := s.Lc.WhoIs(ctx, addr)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return identityFrom(res)
+}
+
+func identityFrom(res *apitype.WhoIsResponse) (*Identity, error) {
+	if res == nil || res.Node == nil {
+		return nil, errors.New("no node found for address")
+	}
+
+	id := &Identity{
+		Node:      strings.TrimSuffix(res.Node.Name, "."),
+		NodeID:    string(res.Node.StableID),
+		NodeKey:   res.Node.Key.String(),
+		Tags:      res.Node.Tags,
+		Addresses: []string{},
+	}
+
+	if id.Tags == nil {
+		id.Tags = []string{}
+	}
+
+	for _, p := range res.Node.Addresses {
+		id.Addresses = append(id.Addresses, p.Addr().String())
+	}
+
+	if !res.Node.IsTagged() && res.UserProfile != nil {
+		id.Login = res.UserProfile.LoginName
+		id.DisplayName = res.UserProfile.DisplayName
+		id.ProfilePicURL = res.UserProfile.ProfilePicURL
+	}
+
+	return id, nil
+}
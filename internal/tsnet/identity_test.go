@@ -0,0 +1,94 @@
+package tsnet
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestIdentityFrom(t *testing.T) {
+	nodeKey := key.NewNode().Public()
+	addrs := []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32"), netip.MustParsePrefix("fd7a:115c:a1e0::1/128")}
+	profile := &tailcfg.UserProfile{LoginName: "alice@example.com", DisplayName: "Alice", ProfilePicURL: "https://example.com/a.png"}
+
+	tests := []struct {
+		name    string
+		res     *apitype.WhoIsResponse
+		want    *Identity
+		wantErr string
+	}{
+		{name: "nil response", wantErr: "no node found"},
+		{name: "no node", res: &apitype.WhoIsResponse{UserProfile: profile}, wantErr: "no node found"},
+		{
+			name: "user node",
+			res: &apitype.WhoIsResponse{
+				Node:        &tailcfg.Node{Name: "laptop.example.ts.net.", StableID: "n1", Key: nodeKey, Addresses: addrs},
+				UserProfile: profile,
+			},
+			want: &Identity{
+				Login:         "alice@example.com",
+				DisplayName:   "Alice",
+				ProfilePicURL: "https://example.com/a.png",
+				Node:          "laptop.example.ts.net",
+				NodeID:        "n1",
+				NodeKey:       nodeKey.String(),
+				Tags:          []string{},
+				Addresses:     []string{"100.64.0.1", "fd7a:115c:a1e0::1"},
+			},
+		},
+		{
+			name: "tagged node has no user",
+			res: &apitype.WhoIsResponse{
+				Node:        &tailcfg.Node{Name: "ci.example.ts.net.", StableID: "n2", Key: nodeKey, Tags: []string{"tag:ci"}},
+				UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+			},
+			want: &Identity{
+				Node:      "ci.example.ts.net",
+				NodeID:    "n2",
+				NodeKey:   nodeKey.String(),
+				Tags:      []string{"tag:ci"},
+				Addresses: []string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := identityFrom(tt.res)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("identityFrom error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("identityFrom: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("identityFrom = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentifyAddress(t *testing.T) {
+	tests := []string{"", "laptop", "100.64.0.1:port", "nodekey:abc"}
+
+	for _, addr := range tests {
+		t.Run(addr, func(t *testing.T) {
+			_, err := new(TSAgent).Identify(context.Background(), addr)
+			if err == nil || !strings.Contains(err.Error(), "address must be an IP") {
+				t.Errorf("Identify(%q) error = %v", addr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package tsnet
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/netmap"
+)
+
+// NetMap returns the current network map by reading the initial state from
+// the IPN bus.
+func (s *TSAgent) NetMap(ctx context.Context) (*netmap.NetworkMap, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	watcher, err := s.Lc.WatchIPNBus(ctx, ipn.NotifyInitialNetMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch IPN bus: %w", err)
+	}
+	defer watcher.Close()
+
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read netmap: %w", err)
+		}
+
+		if n.NetMap != nil {
+			return n.NetMap, nil
+		}
+	}
+}
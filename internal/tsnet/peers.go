@@ -9,7 +9,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/tale/headplane/internal/metrics"
+	"github.com/tale/headplane/internal/tracing"
 	"github.com/tale/headplane/internal/util"
+	"go.opentelemetry.io/otel/attribute"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
@@ -69,9 +72,16 @@ func (s *TSAgent) GetStatusForPeer(id string) (*tailcfg.HostinfoView, error) {
 	return &whois.Node.Hostinfo, nil
 }
 
-// FetchAllHostInfo fetches hostinfo for all peers and returns them as a map
-// keyed by node public key (e.g., "nodekey:abc123...").
-func (s *TSAgent) FetchAllHostInfo(ctx context.Context) (map[string]json.RawMessage, error) {
+// Node pairs a peer's status with the full node record returned by WhoIs.
+type Node struct {
+	ID     string
+	Status *ipnstate.PeerStatus
+	Node   *tailcfg.Node
+}
+
+// FetchNodes queries WhoIs for every peer (and ourselves) and returns the
+// results keyed by node public key. Peers whose lookup fails are omitted.
+func (s *TSAgent) FetchNodes(ctx context.Context) (map[string]*Node, error) {
 	log := util.GetLogger()
 
 	stat, err := s.Lc.Status(ctx)
@@ -96,7 +106,7 @@ func (s *TSAgent) FetchAllHostInfo(ctx context.Context) (map[string]json.RawMess
 		nodeMap[nodeKey] = peer
 	}
 
-	result := make(map[string]json.RawMessage)
+	result := make(map[string]*Node)
 
 	for nodeKey, peer := range nodeMap {
 		idBytes, err := nodeKey.MarshalText()
@@ -106,6 +116,11 @@ func (s *TSAgent) FetchAllHostInfo(ctx context.Context) (map[string]json.RawMess
 		}
 
 		nodeID := string(idBytes)
+		if len(peer.TailscaleIPs) == 0 {
+			log.Debug("Peer %s has no Tailscale IPs", nodeID)
+			continue
+		}
+
 		wg.Add(1)
 		sema <- struct{}{}
 
@@ -117,49 +132,33 @@ func (s *TSAgent) FetchAllHostInfo(ctx context.Context) (map[string]json.RawMess
 			defer cancel()
 
 			ip := peer.TailscaleIPs[0].String()
-			if len(ip) == 0 {
-				log.Debug("Peer %s has no Tailscale IPs", nodeID)
-				return
-			}
+			wctx, span := tracing.Start(wctx, "tailscale.whois",
+				attribute.String("node.id", nodeID),
+				attribute.String("node.ip", ip),
+			)
 
 			whois, err := s.Lc.WhoIs(wctx, ip)
+			tracing.End(span, err)
 			if err != nil {
 				log.Debug("WhoIs failed for %s (%s): %s", nodeID, ip, err)
+				if wctx.Err() != nil {
+					metrics.WhoIs.Add("timeout", 1)
+				} else {
+					metrics.WhoIs.Add("error", 1)
+				}
 				return
 			}
 
-			if whois == nil || whois.Node == nil {
-				log.Debug("WhoIs returned nil node for %s (%s)", nodeID, ip)
-				return
-			}
-
-			// Merge hostinfo with connection status from PeerStatus
-			var merged map[string]any
-			raw, err := json.Marshal(whois.Node.Hostinfo)
-			if err != nil {
-				log.Debug("Failed to marshal hostinfo for %s (%s): %s", nodeID, ip, err)
-				return
-			}
-			if err := json.Unmarshal(raw, &merged); err != nil {
-				log.Debug("Failed to unmarshal hostinfo for %s (%s): %s", nodeID, ip, err)
+			if whois == nil || whois.Node == nil || !whois.Node.Hostinfo.Valid() {
+				log.Debug("WhoIs returned no node or hostinfo for %s (%s)", nodeID, ip)
+				metrics.WhoIs.Add("error", 1)
 				return
 			}
 
-			endpoints := make([]string, len(whois.Node.Endpoints))
-			for i, ep := range whois.Node.Endpoints {
-				endpoints[i] = ep.String()
-			}
-			merged["Endpoints"] = endpoints
-			merged["HomeDERP"] = whois.Node.HomeDERP
-
-			data, err := json.Marshal(merged)
-			if err != nil {
-				log.Debug("Failed to marshal merged info for %s (%s): %s", nodeID, ip, err)
-				return
-			}
+			metrics.WhoIs.Add("ok", 1)
 
 			mu.Lock()
-			result[nodeID] = json.RawMessage(data)
+			result[nodeID] = &Node{ID: nodeID, Status: peer, Node: whois.Node}
 			mu.Unlock()
 		}()
 	}
@@ -167,3 +166,52 @@ func (s *TSAgent) FetchAllHostInfo(ctx context.Context) (map[string]json.RawMess
 	wg.Wait()
 	return result, nil
 }
+
+// FetchAllHostInfo fetches hostinfo for all peers and returns them as a map
+// keyed by node public key (e.g., "nodekey:abc123...").
+func (s *TSAgent) FetchAllHostInfo(ctx context.Context) (map[string]json.RawMessage, error) {
+	nodes, err := s.FetchNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return HostInfoPayloads(nodes), nil
+}
+
+// HostInfoPayloads converts fetched nodes into the hostinfo payloads sent
+// to Headplane, keyed by node public key.
+func HostInfoPayloads(nodes map[string]*Node) map[string]json.RawMessage {
+	log := util.GetLogger()
+	result := make(map[string]json.RawMessage, len(nodes))
+
+	for nodeID, n := range nodes {
+		// Merge hostinfo with connection status from PeerStatus
+		var merged map[string]any
+		raw, err := json.Marshal(n.Node.Hostinfo)
+		if err != nil {
+			log.Debug("Failed to marshal hostinfo for %s: %s", nodeID, err)
+			continue
+		}
+		if err := json.Unmarshal(raw, &merged); err != nil {
+			log.Debug("Failed to unmarshal hostinfo for %s: %s", nodeID, err)
+			continue
+		}
+
+		endpoints := make([]string, len(n.Node.Endpoints))
+		for i, ep := range n.Node.Endpoints {
+			endpoints[i] = ep.String()
+		}
+		merged["Endpoints"] = endpoints
+		merged["HomeDERP"] = n.Node.HomeDERP
+
+		data, err := json.Marshal(merged)
+		if err != nil {
+			log.Debug("Failed to marshal merged info for %s: %s", nodeID, err)
+			continue
+		}
+
+		result[nodeID] = json.RawMessage(data)
+	}
+
+	return result
+}
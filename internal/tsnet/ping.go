@@ -0,0 +1,86 @@
+package tsnet
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/tracing"
+	"github.com/tale/headplane/internal/util"
+	"go.opentelemetry.io/otel/attribute"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// PathInfo describes how the agent reaches a node at a point in time.
+type PathInfo struct {
+	Online  bool
+	Path    string // direct, derp, peer-relay or none
+	DERP    string
+	Latency time.Duration
+}
+
+// Ping sends a disco ping to ip and waits up to 3 seconds for a reply.
+func (s *TSAgent) Ping(ctx context.Context, ip netip.Addr) (*ipnstate.PingResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "tailscale.ping", attribute.String("node.ip", ip.String()))
+	res, err := s.Lc.Ping(ctx, ip, tailcfg.PingDisco)
+	if err == nil && res.Err != "" {
+		err = errors.New(res.Err)
+	}
+
+	tracing.End(span, err)
+	return res, err
+}
+
+// ProbePaths pings every online node and reports the path taken to reach
+// it. Offline nodes and ourselves are reported without pinging.
+func (s *TSAgent) ProbePaths(ctx context.Context, nodes map[string]*Node) map[string]*PathInfo {
+	log := util.GetLogger()
+
+	const maxParallel = 8
+	sema := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	result := make(map[string]*PathInfo, len(nodes))
+	for id, n := range nodes {
+		info := &PathInfo{Online: n.Status.Online, Path: "none", DERP: n.Status.Relay}
+		result[id] = info
+
+		if !n.Status.Online || id == s.ID || len(n.Status.TailscaleIPs) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		sema <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sema }()
+
+			res, err := s.Ping(ctx, n.Status.TailscaleIPs[0])
+			if err != nil {
+				log.Debug("Ping failed for %s: %s", id, err)
+				return
+			}
+
+			info.Latency = time.Duration(res.LatencySeconds * float64(time.Second))
+			switch {
+			case res.Endpoint != "":
+				info.Path = "direct"
+			case res.PeerRelay != "":
+				info.Path = "peer-relay"
+			case res.DERPRegionID != 0:
+				info.Path = "derp"
+				info.DERP = res.DERPRegionCode
+			}
+		}()
+	}
+
+	wg.Wait()
+	return result
+}
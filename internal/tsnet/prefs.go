@@ -0,0 +1,32 @@
+package tsnet
+
+import (
+	"context"
+
+	"tailscale.com/ipn"
+)
+
+// SetAcceptRoutes sets whether the agent uses subnet routes advertised by
+// other nodes. The pref is persisted in the agent state, so it is applied on
+// every start to follow the configuration.
+func (s *TSAgent) SetAcceptRoutes(ctx context.Context, accept bool) error {
+	_, err := s.Lc.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs:       ipn.Prefs{RouteAll: accept},
+		RouteAllSet: true,
+	})
+
+	return err
+}
+
+// ClearExitNode stops the agent using an exit node. Exit node checks switch
+// the pref and put it back when they finish, but it is persisted in the
+// agent state, so a crash mid-check would leave the agent egressing through
+// an exit node. It is cleared on every start for that reason.
+func (s *TSAgent) ClearExitNode(ctx context.Context) error {
+	_, err := s.Lc.EditPrefs(ctx, &ipn.MaskedPrefs{
+		ExitNodeIDSet: true,
+		ExitNodeIPSet: true,
+	})
+
+	return err
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/tale/headplane/internal/config"
+	"github.com/tale/headplane/internal/tracing"
 	"github.com/tale/headplane/internal/util"
 	"tailscale.com/client/local"
+	"tailscale.com/ipn"
 	"tailscale.com/tsnet"
+	"tailscale.com/types/netmap"
 )
 
 // Wrapper type so we can add methods to the server.
@@ -16,6 +20,18 @@ type TSAgent struct {
 	*tsnet.Server
 	Lc *local.Client
 	ID string
+
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	netmapHooks []func(*netmap.NetworkMap)
+	state       ipn.State
+
+	// exitMu serializes exit node checks, which change the agent's prefs.
+	// While one runs, egress holds back other traffic that could leave
+	// through the exit node and restoreExit undoes the pref change.
+	exitMu      sync.Mutex
+	egress      egressGate
+	restoreExit func()
 }
 
 // Creates a new tsnet agent and returns an instance of the server.
@@ -44,15 +60,17 @@ func NewAgent(cfg *config.Config) *TSAgent {
 		server.Logf = log.Debug
 	}
 
-	return &TSAgent{server, nil, ""}
+	return &TSAgent{Server: server}
 }
 
 // Starts the tsnet agent and sets the node ID.
-func (s *TSAgent) Connect() {
+func (s *TSAgent) Connect(ctx context.Context) {
 	log := util.GetLogger()
 
 	// Waits until the agent is up and running.
-	status, err := s.Up(context.Background())
+	ctx, span := tracing.Start(ctx, "tsnet.up")
+	status, err := s.Up(ctx)
+	tracing.End(span, err)
 	if err != nil {
 		log.Fatal("Failed to connect to Tailnet: %s", err)
 	}
@@ -69,9 +87,28 @@ func (s *TSAgent) Connect() {
 
 	log.Info("Connected to Tailnet (PublicKey: %s)", status.Self.PublicKey)
 	s.ID = string(id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.watchIPNBus(ctx)
 }
 
 // Shuts down the tsnet agent.
 func (s *TSAgent) Shutdown() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	// An exit node check may be running; put the agent's own exit node
+	// back before the state is saved for the last time.
+	s.mu.Lock()
+	restore := s.restoreExit
+	s.restoreExit = nil
+	s.mu.Unlock()
+
+	if restore != nil {
+		restore()
+	}
+
 	s.Close()
 }
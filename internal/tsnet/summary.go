@@ -0,0 +1,76 @@
+package tsnet
+
+import (
+	"fmt"
+
+	"tailscale.com/net/tsaddr"
+)
+
+// FleetSummary holds aggregate counts across every node the agent can see.
+type FleetSummary struct {
+	Total         int            `json:"total"`
+	Online        int            `json:"online"`
+	Offline       int            `json:"offline"`
+	OS            map[string]int `json:"os"`
+	OSVersion     map[string]int `json:"osVersion"`
+	Distro        map[string]int `json:"distro"`
+	ClientVersion map[string]int `json:"clientVersion"`
+	DERPRegion    map[string]int `json:"derpRegion"`
+	SubnetRouters int            `json:"subnetRouters"`
+	ExitNodes     int            `json:"exitNodes"`
+}
+
+// Summarize aggregates the hostinfo and connection status of the given nodes.
+// Missing values are counted under "unknown" so the totals always add up.
+func Summarize(nodes map[string]*Node) *FleetSummary {
+	sum := &FleetSummary{
+		OS:            make(map[string]int),
+		OSVersion:     make(map[string]int),
+		Distro:        make(map[string]int),
+		ClientVersion: make(map[string]int),
+		DERPRegion:    make(map[string]int),
+	}
+
+	for _, n := range nodes {
+		hi := n.Node.Hostinfo
+		sum.Total++
+
+		if n.Status.Online {
+			sum.Online++
+		} else {
+			sum.Offline++
+		}
+
+		os := orUnknown(hi.OS())
+		sum.OS[os]++
+		sum.OSVersion[fmt.Sprintf("%s %s", os, orUnknown(hi.OSVersion()))]++
+		if hi.Distro() != "" {
+			sum.Distro[fmt.Sprintf("%s %s", hi.Distro(), orUnknown(hi.DistroVersion()))]++
+		}
+
+		sum.ClientVersion[orUnknown(hi.IPNVersion())]++
+		sum.DERPRegion[orUnknown(n.Status.Relay)]++
+
+		routes := hi.RoutableIPs()
+		if tsaddr.ContainsExitRoute(routes) {
+			sum.ExitNodes++
+		}
+
+		for _, p := range routes.All() {
+			if !tsaddr.IsExitRoute(p) {
+				sum.SubnetRouters++
+				break
+			}
+		}
+	}
+
+	return sum
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+
+	return s
+}
@@ -0,0 +1,73 @@
+package tsnet
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+func testNode(online bool, relay string, hi *tailcfg.Hostinfo) *Node {
+	return &Node{
+		Status: &ipnstate.PeerStatus{Online: online, Relay: relay},
+		Node:   &tailcfg.Node{Hostinfo: hi.View()},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes map[string]*Node
+		want  *FleetSummary
+	}{
+		{
+			name:  "empty",
+			nodes: map[string]*Node{},
+			want: &FleetSummary{
+				OS:            map[string]int{},
+				OSVersion:     map[string]int{},
+				Distro:        map[string]int{},
+				ClientVersion: map[string]int{},
+				DERPRegion:    map[string]int{},
+			},
+		},
+		{
+			name: "mixed fleet",
+			nodes: map[string]*Node{
+				"a": testNode(true, "fra", &tailcfg.Hostinfo{
+					OS: "linux", OSVersion: "6.1", Distro: "debian", DistroVersion: "12",
+					IPNVersion:  "1.88.2",
+					RoutableIPs: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")},
+				}),
+				"b": testNode(false, "fra", &tailcfg.Hostinfo{
+					OS: "linux", OSVersion: "6.1", IPNVersion: "1.88.2",
+					RoutableIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+				}),
+				"c": testNode(true, "", &tailcfg.Hostinfo{}),
+			},
+			want: &FleetSummary{
+				Total:         3,
+				Online:        2,
+				Offline:       1,
+				OS:            map[string]int{"linux": 2, "unknown": 1},
+				OSVersion:     map[string]int{"linux 6.1": 2, "unknown unknown": 1},
+				Distro:        map[string]int{"debian 12": 1},
+				ClientVersion: map[string]int{"1.88.2": 2, "unknown": 1},
+				DERPRegion:    map[string]int{"fra": 2, "unknown": 1},
+				SubnetRouters: 1,
+				ExitNodes:     1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Summarize(tt.nodes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Summarize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package tsnet
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// Vertex kinds in a topology graph.
+const (
+	VertexSelf     = "self"
+	VertexNode     = "node"
+	VertexDERP     = "derp"
+	VertexSubnet   = "subnet"
+	VertexInternet = "internet"
+)
+
+// Edge kinds in a topology graph. Home edges link a node to its home DERP
+// region; direct, derp and peer-relay edges describe how the agent reaches
+// a peer; route edges link a router to a subnet it advertises and exit
+// edges link an exit node to the internet.
+const (
+	EdgeHome      = "home"
+	EdgeDirect    = "direct"
+	EdgeDERP      = "derp"
+	EdgePeerRelay = "peer-relay"
+	EdgeRoute     = "route"
+	EdgeExit      = "exit"
+)
+
+// Vertex is a node, DERP region, subnet or the internet.
+type Vertex struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Label  string `json:"label"`
+	Online bool   `json:"online,omitempty"`
+}
+
+// Edge connects two vertices. Primary is set on route edges from the
+// primary router for a subnet.
+type Edge struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Kind    string `json:"kind"`
+	Label   string `json:"label,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Graph is the tailnet topology as seen by the agent.
+type Graph struct {
+	Vertices []Vertex `json:"vertices"`
+	Edges    []Edge   `json:"edges"`
+}
+
+// Topology builds a graph from the local status and netmap. Paths only
+// exist for peers the agent has talked to recently; idle peers have no path
+// edge.
+func (s *TSAgent) Topology(ctx context.Context) (*Graph, error) {
+	st, err := s.Lc.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+
+	nm, err := s.NetMap(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildTopology(st, advertisedRoutes(nm)), nil
+}
+
+// advertisedRoutes returns the routes each node advertises in its
+// Hostinfo, keyed by stable node ID.
+func advertisedRoutes(nm *netmap.NetworkMap) map[tailcfg.StableNodeID][]netip.Prefix {
+	routes := make(map[tailcfg.StableNodeID][]netip.Prefix)
+	for _, n := range slices.Concat(nm.Peers, []tailcfg.NodeView{nm.SelfNode}) {
+		if n.Valid() && n.Hostinfo().Valid() {
+			routes[n.StableID()] = n.Hostinfo().RoutableIPs().AsSlice()
+		}
+	}
+
+	return routes
+}
+
+// buildTopology builds the graph for st. Subnet edges come from each node's
+// primary routes and the routes it advertises; AllowedIPs is not used as it
+// only holds routes the agent accepted.
+func buildTopology(st *ipnstate.Status, advertised map[tailcfg.StableNodeID][]netip.Prefix) *Graph {
+	g := &Graph{Vertices: []Vertex{}, Edges: []Edge{}}
+	seen := make(map[string]bool)
+	vertex := func(v Vertex) {
+		if !seen[v.ID] {
+			seen[v.ID] = true
+			g.Vertices = append(g.Vertices, v)
+		}
+	}
+
+	routed := make(map[Edge]bool)
+	routes := func(id string, p *ipnstate.PeerStatus) {
+		var primary []netip.Prefix
+		if p.PrimaryRoutes != nil {
+			primary = p.PrimaryRoutes.AsSlice()
+		}
+
+		for _, r := range slices.Concat(primary, advertised[p.ID]) {
+			subnet := "subnet:" + r.String()
+			if tsaddr.IsExitRoute(r) || routed[Edge{From: id, To: subnet}] {
+				continue
+			}
+
+			routed[Edge{From: id, To: subnet}] = true
+			vertex(Vertex{ID: subnet, Kind: VertexSubnet, Label: r.String()})
+			g.Edges = append(g.Edges, Edge{
+				From:    id,
+				To:      subnet,
+				Kind:    EdgeRoute,
+				Primary: slices.Contains(primary, r),
+			})
+		}
+	}
+
+	self := "node:" + string(st.Self.ID)
+	vertex(Vertex{ID: self, Kind: VertexSelf, Label: peerLabel(st.Self), Online: true})
+	addHome(g, vertex, self, st.Self.Relay)
+	routes(self, st.Self)
+
+	for _, p := range st.Peer {
+		if p == nil {
+			continue
+		}
+
+		id := "node:" + string(p.ID)
+		vertex(Vertex{ID: id, Kind: VertexNode, Label: peerLabel(p), Online: p.Online})
+		addHome(g, vertex, id, p.Relay)
+		routes(id, p)
+
+		switch {
+		case !p.Online:
+		case p.CurAddr != "":
+			g.Edges = append(g.Edges, Edge{From: self, To: id, Kind: EdgeDirect, Label: p.CurAddr})
+		case p.PeerRelay != "":
+			g.Edges = append(g.Edges, Edge{From: self, To: id, Kind: EdgePeerRelay, Label: p.PeerRelay})
+		case p.Active && p.Relay != "":
+			g.Edges = append(g.Edges, Edge{From: self, To: id, Kind: EdgeDERP, Label: p.Relay})
+		}
+
+		if p.ExitNodeOption {
+			vertex(Vertex{ID: "internet", Kind: VertexInternet, Label: "Internet"})
+			g.Edges = append(g.Edges, Edge{From: id, To: "internet", Kind: EdgeExit})
+		}
+	}
+
+	slices.SortFunc(g.Vertices, func(a, b Vertex) int { return strings.Compare(a.ID, b.ID) })
+	slices.SortFunc(g.Edges, func(a, b Edge) int {
+		return strings.Compare(a.From+" "+a.To+" "+a.Kind, b.From+" "+b.To+" "+b.Kind)
+	})
+
+	return g
+}
+
+func addHome(g *Graph, vertex func(Vertex), id, region string) {
+	if region == "" {
+		return
+	}
+
+	derp := "derp:" + region
+	vertex(Vertex{ID: derp, Kind: VertexDERP, Label: "DERP " + region})
+	g.Edges = append(g.Edges, Edge{From: id, To: derp, Kind: EdgeHome})
+}
+
+func peerLabel(p *ipnstate.PeerStatus) string {
+	if name := strings.TrimSuffix(p.DNSName, "."); name != "" {
+		short, _, _ := strings.Cut(name, ".")
+		return short
+	}
+
+	return p.HostName
+}
+
+// DOT renders the graph in GraphViz DOT format. Nodes are boxes, DERP
+// regions ellipses and subnets notes; home edges are dotted and paths
+// through a relay dashed.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("graph tailnet {\n")
+	b.WriteString("  node [fontname=\"sans-serif\"];\n")
+
+	for _, v := range g.Vertices {
+		attrs := []string{"label=" + dotQuote(v.Label)}
+		switch v.Kind {
+		case VertexSelf:
+			attrs = append(attrs, "shape=box", "style=bold")
+		case VertexNode:
+			attrs = append(attrs, "shape=box")
+			if !v.Online {
+				attrs = append(attrs, "color=gray", "fontcolor=gray")
+			}
+		case VertexDERP:
+			attrs = append(attrs, "shape=ellipse")
+		case VertexSubnet:
+			attrs = append(attrs, "shape=note")
+		case VertexInternet:
+			attrs = append(attrs, "shape=doublecircle")
+		}
+
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(v.ID), strings.Join(attrs, ", "))
+	}
+
+	for _, e := range g.Edges {
+		attrs := []string{}
+		if e.Label != "" {
+			attrs = append(attrs, "label="+dotQuote(e.Label))
+		}
+
+		switch e.Kind {
+		case EdgeHome:
+			attrs = append(attrs, "style=dotted")
+		case EdgeDERP, EdgePeerRelay:
+			attrs = append(attrs, "style=dashed")
+		case EdgeRoute:
+			if !e.Primary {
+				attrs = append(attrs, "style=dashed")
+			}
+		}
+
+		fmt.Fprintf(&b, "  %s -- %s [%s];\n", dotQuote(e.From), dotQuote(e.To), strings.Join(attrs, ", "))
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote returns s as a DOT quoted string. Only double quotes and
+// backslashes are escaped; unlike strconv.Quote, non-ASCII characters are
+// left alone, as GraphViz reads UTF-8.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
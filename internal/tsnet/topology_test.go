@@ -0,0 +1,121 @@
+package tsnet
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+)
+
+func TestBuildTopology(t *testing.T) {
+	lan := netip.MustParsePrefix("10.0.0.0/24")
+	exit := netip.MustParsePrefix("0.0.0.0/0")
+	self := &ipnstate.PeerStatus{ID: "self", HostName: "agent", Relay: "fra"}
+
+	tests := []struct {
+		name       string
+		peer       *ipnstate.PeerStatus
+		advertised map[tailcfg.StableNodeID][]netip.Prefix
+		want       []Edge
+	}{
+		{
+			name: "direct path",
+			peer: &ipnstate.PeerStatus{ID: "a", HostName: "a", Online: true, CurAddr: "192.0.2.1:41641"},
+			want: []Edge{
+				{From: "node:self", To: "derp:fra", Kind: EdgeHome},
+				{From: "node:self", To: "node:a", Kind: EdgeDirect, Label: "192.0.2.1:41641"},
+			},
+		},
+		{
+			name: "primary router",
+			peer: &ipnstate.PeerStatus{
+				ID:            "a",
+				HostName:      "a",
+				PrimaryRoutes: ptrTo(views.SliceOf([]netip.Prefix{lan})),
+			},
+			advertised: map[tailcfg.StableNodeID][]netip.Prefix{"a": {lan, exit}},
+			want: []Edge{
+				{From: "node:a", To: "subnet:10.0.0.0/24", Kind: EdgeRoute, Primary: true},
+				{From: "node:self", To: "derp:fra", Kind: EdgeHome},
+			},
+		},
+		{
+			name:       "standby router without accepted routes",
+			peer:       &ipnstate.PeerStatus{ID: "a", HostName: "a"},
+			advertised: map[tailcfg.StableNodeID][]netip.Prefix{"a": {lan}},
+			want: []Edge{
+				{From: "node:a", To: "subnet:10.0.0.0/24", Kind: EdgeRoute},
+				{From: "node:self", To: "derp:fra", Kind: EdgeHome},
+			},
+		},
+		{
+			name: "exit node",
+			peer: &ipnstate.PeerStatus{ID: "a", HostName: "a", ExitNodeOption: true},
+			advertised: map[tailcfg.StableNodeID][]netip.Prefix{
+				"a": {exit, netip.MustParsePrefix("::/0")},
+			},
+			want: []Edge{
+				{From: "node:a", To: "internet", Kind: EdgeExit},
+				{From: "node:self", To: "derp:fra", Kind: EdgeHome},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &ipnstate.Status{
+				Self: self,
+				Peer: map[key.NodePublic]*ipnstate.PeerStatus{key.NewNode().Public(): tt.peer},
+			}
+
+			g := buildTopology(st, tt.advertised)
+			if !reflect.DeepEqual(g.Edges, tt.want) {
+				t.Errorf("edges = %+v, want %+v", g.Edges, tt.want)
+			}
+		})
+	}
+}
+
+func TestDOTQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"web", `"web"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\temp`, `"C:\\temp"`},
+		{"büro", `"büro"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := dotQuote(tt.in); got != tt.want {
+				t.Errorf("dotQuote(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDOT(t *testing.T) {
+	g := &Graph{
+		Vertices: []Vertex{{ID: "node:a", Kind: VertexNode, Label: "büro"}},
+		Edges:    []Edge{{From: "node:a", To: "subnet:10.0.0.0/24", Kind: EdgeRoute}},
+	}
+
+	dot := g.DOT()
+	for _, want := range []string{
+		`"node:a" [label="büro", shape=box, color=gray, fontcolor=gray];`,
+		`"node:a" -- "subnet:10.0.0.0/24" [style=dashed];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("DOT is missing %s:\n%s", want, dot)
+		}
+	}
+}
+
+func ptrTo[T any](v T) *T { return &v }
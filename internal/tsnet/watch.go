@@ -0,0 +1,76 @@
+package tsnet
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/tale/headplane/internal/metrics"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/ipn"
+	"tailscale.com/types/netmap"
+)
+
+// watchIPNBus follows backend state and netmap updates for as long as the
+// agent is running, reconnecting to the bus if the watch fails.
+func (s *TSAgent) watchIPNBus(ctx context.Context) {
+	log := util.GetLogger()
+
+	for ctx.Err() == nil {
+		watcher, err := s.Lc.WatchIPNBus(ctx, ipn.NotifyInitialState|ipn.NotifyInitialNetMap|ipn.NotifyNoPrivateKeys)
+		if err != nil {
+			log.Debug("Failed to watch IPN bus: %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		for {
+			n, err := watcher.Next()
+			if err != nil {
+				log.Debug("IPN bus watch ended: %s", err)
+				break
+			}
+
+			if n.State != nil {
+				metrics.SetBackendState(n.State.String())
+				s.mu.Lock()
+				s.state = *n.State
+				s.mu.Unlock()
+			}
+
+			if n.NetMap != nil {
+				metrics.ObserveNetMap(time.Now())
+				s.egress.observe(n.NetMap)
+				s.notifyNetMap(n.NetMap)
+			}
+		}
+
+		watcher.Close()
+	}
+}
+
+// OnNetMap registers fn to be called with every netmap the agent receives.
+// Callbacks run on the watcher goroutine and must not block for long.
+func (s *TSAgent) OnNetMap(fn func(*netmap.NetworkMap)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.netmapHooks = append(s.netmapHooks, fn)
+}
+
+func (s *TSAgent) notifyNetMap(nm *netmap.NetworkMap) {
+	s.mu.Lock()
+	hooks := slices.Clone(s.netmapHooks)
+	s.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(nm)
+	}
+}
+
+// Running reports whether the backend is in the Running state, meaning the
+// agent is logged in and connected to control.
+func (s *TSAgent) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state == ipn.Running
+}
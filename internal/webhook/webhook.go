@@ -0,0 +1,364 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/events"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/atomicfile"
+)
+
+// Endpoint is a single webhook receiver. An empty Events list subscribes
+// to every event type. ID identifies the endpoint in the delivery queue and
+// defaults to the URL; set it when two endpoints share a URL.
+type Endpoint struct {
+	ID     string        `json:"id,omitempty"`
+	URL    string        `json:"url"`
+	Secret string        `json:"secret"`
+	Events []events.Type `json:"events,omitempty"`
+}
+
+const (
+	SignatureHeader = "X-Headplane-Signature-256"
+	TimestampHeader = "X-Headplane-Timestamp"
+	EventHeader     = "X-Headplane-Event"
+	DeliveryHeader  = "X-Headplane-Delivery"
+
+	maxAttempts = 12
+	maxBackoff  = time.Hour
+)
+
+// LoadEndpoints reads the webhook endpoint list from a JSON file.
+func LoadEndpoints(path string) ([]Endpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook config: %w", err)
+	}
+
+	var eps []Endpoint
+	if err := json.Unmarshal(data, &eps); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook config: %w", err)
+	}
+
+	ids := make(map[string]bool, len(eps))
+	for i := range eps {
+		ep := &eps[i]
+		if !strings.HasPrefix(ep.URL, "http://") && !strings.HasPrefix(ep.URL, "https://") {
+			return nil, fmt.Errorf("webhook %d has an invalid URL: %q", i, ep.URL)
+		}
+
+		if ep.Secret == "" {
+			return nil, fmt.Errorf("webhook %d has no secret", i)
+		}
+
+		if ep.ID == "" {
+			ep.ID = ep.URL
+		}
+
+		if ids[ep.ID] {
+			return nil, fmt.Errorf("webhook %d has a duplicate id: %q", i, ep.ID)
+		}
+
+		ids[ep.ID] = true
+	}
+
+	return eps, nil
+}
+
+// delivery is a queued POST of one event to one endpoint. Each delivery is
+// stored as its own file so the queue survives restarts.
+type delivery struct {
+	Endpoint    string       `json:"endpoint"`
+	URL         string       `json:"url"`
+	Event       events.Event `json:"event"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"nextAttempt"`
+
+	file string
+}
+
+// Dispatcher queues events for the configured endpoints and delivers them
+// with exponential backoff.
+type Dispatcher struct {
+	endpoints []Endpoint
+	dir       string
+	client    *http.Client
+
+	mu    sync.Mutex
+	queue []*delivery
+	wake  chan struct{}
+}
+
+// NewDispatcher loads any pending deliveries from the queue in workDir.
+func NewDispatcher(workDir string, endpoints []Endpoint) (*Dispatcher, error) {
+	d := &Dispatcher{
+		endpoints: endpoints,
+		dir:       filepath.Join(workDir, "webhooks"),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		wake:      make(chan struct{}, 1),
+	}
+
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create webhook queue: %w", err)
+	}
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook queue: %w", err)
+	}
+
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(d.dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read queued webhook: %w", err)
+		}
+
+		dl := &delivery{file: e.Name()}
+		if err := json.Unmarshal(data, dl); err != nil {
+			util.GetLogger().Error("Dropping unreadable queued webhook %s: %s", e.Name(), err)
+			os.Remove(filepath.Join(d.dir, e.Name()))
+			continue
+		}
+
+		// Deliveries queued before endpoints had IDs are keyed by URL,
+		// which is also the default ID.
+		if dl.Endpoint == "" {
+			dl.Endpoint = dl.URL
+		}
+
+		d.queue = append(d.queue, dl)
+	}
+
+	return d, nil
+}
+
+// Enqueue persists a delivery of e for every endpoint subscribed to it.
+func (d *Dispatcher) Enqueue(e events.Event) {
+	log := util.GetLogger()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, ep := range d.endpoints {
+		if len(ep.Events) > 0 && !slices.Contains(ep.Events, e.Type) {
+			continue
+		}
+
+		id := sha256.Sum256([]byte(ep.ID))
+		dl := &delivery{
+			Endpoint:    ep.ID,
+			URL:         ep.URL,
+			Event:       e,
+			NextAttempt: time.Now(),
+			file:        fmt.Sprintf("%020d-%x.json", e.ID, id[:8]),
+		}
+
+		if err := d.save(dl); err != nil {
+			log.Error("Failed to queue webhook for %s: %s", ep.URL, err)
+			continue
+		}
+
+		d.queue = append(d.queue, dl)
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers queued events until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		wait := d.deliverDue(ctx)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// deliverDue attempts every delivery whose time has come and returns how
+// long to wait until the next one is due. Each endpoint's deliveries are sent
+// in their own goroutine, so a slow or dead endpoint does not hold up the
+// others. Within an endpoint events are sent in order, and one waiting out a
+// backoff holds back every later event for that endpoint.
+func (d *Dispatcher) deliverDue(ctx context.Context) time.Duration {
+	now := time.Now()
+
+	d.mu.Lock()
+	due := make(map[string][]*delivery)
+	blocked := make(map[string]bool)
+	for _, dl := range d.queue {
+		if blocked[dl.Endpoint] {
+			continue
+		}
+
+		if dl.NextAttempt.After(now) {
+			blocked[dl.Endpoint] = true
+			continue
+		}
+
+		due[dl.Endpoint] = append(due[dl.Endpoint], dl)
+	}
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, dls := range due {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.deliverEndpoint(ctx, dls)
+		}()
+	}
+
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Only the oldest delivery of each endpoint can be sent next.
+	wait := maxBackoff
+	seen := make(map[string]bool)
+	for _, dl := range d.queue {
+		if seen[dl.Endpoint] {
+			continue
+		}
+
+		seen[dl.Endpoint] = true
+		wait = min(wait, time.Until(dl.NextAttempt))
+	}
+
+	return max(wait, 0)
+}
+
+// deliverEndpoint sends the due deliveries of one endpoint in order,
+// stopping at the first one that has to be retried.
+func (d *Dispatcher) deliverEndpoint(ctx context.Context, dls []*delivery) {
+	log := util.GetLogger()
+
+	for _, dl := range dls {
+		err := d.send(ctx, dl)
+		if ctx.Err() != nil {
+			return
+		}
+
+		d.mu.Lock()
+		switch {
+		case err == nil:
+			d.remove(dl)
+		case dl.Attempts+1 >= maxAttempts:
+			log.Error("Giving up on webhook %d to %s after %d attempts: %s", dl.Event.ID, dl.URL, dl.Attempts+1, err)
+			d.remove(dl)
+		default:
+			dl.Attempts++
+			dl.NextAttempt = time.Now().Add(backoff(dl.Attempts))
+			log.Debug("Webhook %d to %s failed (attempt %d): %s", dl.Event.ID, dl.URL, dl.Attempts, err)
+			if err := d.save(dl); err != nil {
+				log.Error("Failed to update queued webhook: %s", err)
+			}
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, dl *delivery) error {
+	idx := slices.IndexFunc(d.endpoints, func(ep Endpoint) bool { return ep.ID == dl.Endpoint })
+	if idx < 0 {
+		// The endpoint was removed from the config since this was queued.
+		return nil
+	}
+
+	ep := d.endpoints[idx]
+
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "headplane-agent")
+	req.Header.Set(EventHeader, string(dl.Event.Type))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(dl.Event.ID, 10))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, Sign(ep.Secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Sign returns the signature header value for a request: the hex
+// HMAC-SHA256 of the timestamp header, a period and the raw request body,
+// prefixed with "sha256=". Covering the timestamp lets receivers reject
+// replayed requests.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func backoff(attempts int) time.Duration {
+	return min(time.Second<<attempts, maxBackoff)
+}
+
+// save writes dl to its queue file. Callers must hold d.mu.
+func (d *Dispatcher) save(dl *delivery) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(filepath.Join(d.dir, dl.file), data, 0600)
+}
+
+// remove drops dl from the queue and deletes its file. Callers must hold
+// d.mu.
+func (d *Dispatcher) remove(dl *delivery) {
+	d.queue = slices.DeleteFunc(d.queue, func(q *delivery) bool { return q == dl })
+	if err := os.Remove(filepath.Join(d.dir, dl.file)); err != nil && !os.IsNotExist(err) {
+		util.GetLogger().Error("Failed to remove delivered webhook: %s", err)
+	}
+}
@@ -0,0 +1,376 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tale/headplane/internal/events"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{
+			name:      "timestamp and body",
+			secret:    "secret",
+			timestamp: "1767225600",
+			body:      `{"id":1}`,
+			want:      "sha256=97b5e286e0787bc828806008c500155f0522c15d4e9d491274c2baaf04c4dd8e",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Sign(tt.secret, tt.timestamp, []byte(tt.body))
+			if got != tt.want {
+				t.Errorf("Sign() = %s, want %s", got, tt.want)
+			}
+
+			// Changing the timestamp must change the signature.
+			if other := Sign(tt.secret, tt.timestamp+"0", []byte(tt.body)); other == got {
+				t.Error("signature does not cover the timestamp")
+			}
+		})
+	}
+}
+
+func TestLoadEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantIDs []string
+		wantErr string
+	}{
+		{
+			name:    "id defaults to the url",
+			config:  `[{"url":"https://a.example.com","secret":"s"},{"id":"b","url":"https://a.example.com","secret":"t"}]`,
+			wantIDs: []string{"https://a.example.com", "b"},
+		},
+		{
+			name:    "invalid url",
+			config:  `[{"url":"ftp://a.example.com","secret":"s"}]`,
+			wantErr: "invalid URL",
+		},
+		{
+			name:    "empty secret",
+			config:  `[{"url":"https://a.example.com"}]`,
+			wantErr: "no secret",
+		},
+		{
+			name:    "duplicate id",
+			config:  `[{"url":"https://a.example.com","secret":"s"},{"url":"https://a.example.com","secret":"t"}]`,
+			wantErr: "duplicate id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "webhooks.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			eps, err := LoadEndpoints(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ids []string
+			for _, ep := range eps {
+				ids = append(ids, ep.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+// receiver is an HTTP stand-in for a webhook endpoint that fails the first
+// failures requests and records every request it accepts.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	got      []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.attempts++
+	if r.attempts <= r.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	r.got = append(r.got, req)
+	r.bodies = append(r.bodies, body)
+}
+
+func TestDispatcherDeliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		events       []events.Type
+		want         int
+		wantAttempts int
+		wantQueued   int
+	}{
+		{name: "delivered first time", want: 1, wantAttempts: 1},
+		{name: "retried after failure", failures: 2, want: 1, wantAttempts: 3},
+		{name: "gives up after max attempts", failures: maxAttempts, wantAttempts: maxAttempts},
+		{name: "unsubscribed event", events: []events.Type{events.NodeRemoved}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcv := &receiver{failures: tt.failures}
+			srv := httptest.NewServer(rcv)
+			defer srv.Close()
+
+			workDir := t.TempDir()
+			ep := Endpoint{ID: "ep", URL: srv.URL, Secret: "secret", Events: tt.events}
+			d, err := NewDispatcher(workDir, []Endpoint{ep})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d.Enqueue(events.Event{ID: 7, Type: events.NodeAdded, Node: "n1"})
+
+			// Make every queued delivery due instead of waiting out the
+			// backoff.
+			for range maxAttempts + 1 {
+				d.mu.Lock()
+				for _, dl := range d.queue {
+					dl.NextAttempt = time.Time{}
+				}
+				d.mu.Unlock()
+				d.deliverDue(context.Background())
+			}
+
+			if len(rcv.got) != tt.want {
+				t.Fatalf("got %d deliveries, want %d", len(rcv.got), tt.want)
+			}
+
+			if rcv.attempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", rcv.attempts, tt.wantAttempts)
+			}
+
+			if len(d.queue) != tt.wantQueued {
+				t.Errorf("queue has %d deliveries, want %d", len(d.queue), tt.wantQueued)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(workDir, "webhooks", "*.json"))
+			if len(files) != tt.wantQueued {
+				t.Errorf("queue directory has %d files, want %d", len(files), tt.wantQueued)
+			}
+
+			for i, req := range rcv.got {
+				ts := req.Header.Get(TimestampHeader)
+				if want := Sign("secret", ts, rcv.bodies[i]); req.Header.Get(SignatureHeader) != want {
+					t.Errorf("signature = %s, want %s", req.Header.Get(SignatureHeader), want)
+				}
+
+				if req.Header.Get(EventHeader) != string(events.NodeAdded) || req.Header.Get(DeliveryHeader) != "7" {
+					t.Errorf("unexpected headers: %v", req.Header)
+				}
+
+				var e events.Event
+				if err := json.Unmarshal(rcv.bodies[i], &e); err != nil || e.Node != "n1" {
+					t.Errorf("unexpected body %s: %v", rcv.bodies[i], err)
+				}
+			}
+		})
+	}
+}
+
+func TestDispatcherQueue(t *testing.T) {
+	tests := []struct {
+		name string
+		// reload is the endpoint list after a restart.
+		reload []Endpoint
+		want   map[string]int
+	}{
+		{
+			name: "reordered endpoints keep their deliveries",
+			reload: []Endpoint{
+				{ID: "b", Secret: "s"},
+				{ID: "a", Secret: "s"},
+			},
+			want: map[string]int{"a": 1, "b": 1},
+		},
+		{
+			name: "endpoints sharing a url are distinct",
+			reload: []Endpoint{
+				{ID: "a", Secret: "s"},
+				{ID: "b", Secret: "s"},
+			},
+			want: map[string]int{"a": 1, "b": 1},
+		},
+		{
+			name:   "removed endpoint is dropped",
+			reload: []Endpoint{{ID: "b", Secret: "s"}},
+			want:   map[string]int{"b": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]int{}
+			var mu sync.Mutex
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				got[strings.TrimPrefix(r.URL.Path, "/")]++
+			}))
+			defer srv.Close()
+
+			// Queue one event for two endpoints without delivering it.
+			workDir := t.TempDir()
+			d, err := NewDispatcher(workDir, []Endpoint{
+				{ID: "a", URL: srv.URL + "/a", Secret: "s"},
+				{ID: "b", URL: srv.URL + "/b", Secret: "s"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.Enqueue(events.Event{ID: 1, Type: events.NodeAdded})
+
+			for i := range tt.reload {
+				tt.reload[i].URL = srv.URL + "/" + tt.reload[i].ID
+			}
+
+			restarted, err := NewDispatcher(workDir, tt.reload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(restarted.queue) != 2 {
+				t.Fatalf("reloaded %d deliveries, want 2", len(restarted.queue))
+			}
+
+			restarted.deliverDue(context.Background())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("deliveries = %v, want %v", got, tt.want)
+			}
+
+			if len(restarted.queue) != 0 {
+				t.Errorf("queue has %d deliveries after delivery", len(restarted.queue))
+			}
+		})
+	}
+}
+
+func TestDispatcherEndpoints(t *testing.T) {
+	tests := []struct {
+		name string
+		// failures is how many requests the live endpoint fails first.
+		failures int
+		want     []string
+	}{
+		{name: "dead endpoint does not block the others", want: []string{"1", "2", "3"}},
+		{name: "failed event holds back later ones", failures: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The dead endpoint hangs until the test is over.
+			hang := make(chan struct{})
+			dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-hang
+			}))
+			defer dead.Close()
+			defer close(hang)
+
+			rcv := &receiver{failures: tt.failures}
+			live := httptest.NewServer(rcv)
+			defer live.Close()
+
+			d, err := NewDispatcher(t.TempDir(), []Endpoint{
+				{ID: "dead", URL: dead.URL, Secret: "s"},
+				{ID: "live", URL: live.URL, Secret: "s"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for id := range uint64(3) {
+				d.Enqueue(events.Event{ID: id + 1, Type: events.NodeAdded})
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				d.deliverDue(ctx)
+			}()
+
+			// The live endpoint is done once its queue is empty or its
+			// oldest delivery is waiting out a backoff.
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				d.mu.Lock()
+				idx := slices.IndexFunc(d.queue, func(dl *delivery) bool { return dl.Endpoint == "live" })
+				finished := idx < 0 || d.queue[idx].Attempts > 0
+				d.mu.Unlock()
+
+				if finished {
+					break
+				}
+
+				if time.Now().After(deadline) {
+					t.Fatal("live endpoint was held up by the dead one")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			cancel()
+			<-done
+
+			rcv.mu.Lock()
+			defer rcv.mu.Unlock()
+
+			var got []string
+			for _, req := range rcv.got {
+				got = append(got, req.Header.Get(DeliveryHeader))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("delivered %v, want %v", got, tt.want)
+			}
+
+			if rcv.attempts != len(tt.want)+tt.failures {
+				t.Errorf("got %d attempts, want %d", rcv.attempts, len(tt.want)+tt.failures)
+			}
+		})
+	}
+}
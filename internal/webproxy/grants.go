@@ -0,0 +1,84 @@
+package webproxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/proxy"
+)
+
+// Grant is what a token lets its user open.
+type Grant struct {
+	User    string
+	Allow   []proxy.Rule
+	Expires time.Time
+}
+
+// grants holds the tokens Headplane has issued. Each user has at most one;
+// granting again replaces it.
+type grants struct {
+	mu     sync.Mutex
+	byTok  map[string]*Grant
+	byUser map[string]string
+}
+
+// Grant issues a token that lets user open the nodes and ports in allow,
+// using the same syntax as the tailnet proxy allowlist. Any earlier token
+// for the user stops working.
+func (h *Handler) Grant(user string, allow []string, ttl time.Duration) (string, time.Time, error) {
+	rules, err := proxy.ParseAllowlist(allow)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	expires := time.Now().Add(ttl)
+
+	g := &h.grants
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	for tok, gr := range g.byTok {
+		if now.After(gr.Expires) {
+			delete(g.byTok, tok)
+			delete(g.byUser, gr.User)
+		}
+	}
+
+	if old, ok := g.byUser[user]; ok {
+		delete(g.byTok, old)
+	}
+
+	g.byTok[token] = &Grant{User: user, Allow: rules, Expires: expires}
+	g.byUser[user] = token
+	return token, expires, nil
+}
+
+// Revoke removes the user's token, if any.
+func (h *Handler) Revoke(user string) {
+	g := &h.grants
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if tok, ok := g.byUser[user]; ok {
+		delete(g.byTok, tok)
+		delete(g.byUser, user)
+	}
+}
+
+func (g *grants) lookup(token string) (*Grant, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	gr, ok := g.byTok[token]
+	if !ok || time.Now().After(gr.Expires) {
+		return nil, false
+	}
+
+	return gr, true
+}
@@ -0,0 +1,303 @@
+package webproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/inventory"
+	"github.com/tale/headplane/internal/proxy"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/types/netmap"
+)
+
+const (
+	// TokenHeader carries the token from Grant on every request. It is
+	// removed before the request reaches the node.
+	TokenHeader = "Headplane-Proxy-Token"
+
+	// PrefixHeader is the path Headplane serves the proxy under, used
+	// when rewriting redirects. It is optional.
+	PrefixHeader = "X-Forwarded-Prefix"
+)
+
+// Dialer opens connections over the tailnet. *tsnet.Server satisfies it.
+type Dialer interface {
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// target is a node and port parsed from a request path.
+type target struct {
+	host inventory.Host
+	port string
+	base string
+}
+
+// cookiePrefix namespaces the cookies of one node and port. The browser
+// sees every node under Headplane's origin, so without it nodes could read
+// and overwrite each other's cookies and receive Headplane's own.
+func (t *target) cookiePrefix() string {
+	return "hpn_" + t.host.ID + "_" + t.port + "_"
+}
+
+type targetKey struct{}
+
+// Handler proxies /proxy/<node>/<port>/... to a web server on a tailnet
+// node. The node is a MagicDNS name, short name or Tailscale IP, and only
+// peers in the netmap can be reached. Port 443 is spoken to over HTTPS
+// without verifying the certificate, since the tunnel already
+// authenticates the node; every other port is plain HTTP.
+type Handler struct {
+	proxy  *httputil.ReverseProxy
+	grants grants
+
+	mu    sync.RWMutex
+	hosts map[string]inventory.Host
+}
+
+// New returns a handler that dials nodes through d.
+func New(d Dialer) *Handler {
+	h := &Handler{
+		grants: grants{byTok: make(map[string]*Grant), byUser: make(map[string]string)},
+		hosts:  make(map[string]inventory.Host),
+	}
+
+	transport := &http.Transport{
+		DialContext:           d.Dial,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+
+	h.proxy = &httputil.ReverseProxy{
+		Transport:      transport,
+		Rewrite:        rewrite,
+		ModifyResponse: modifyResponse,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			util.GetLogger().Debug("Web proxy request to %s failed: %s", r.URL.Host, err)
+			http.Error(w, "node did not respond", http.StatusBadGateway)
+		},
+	}
+
+	return h
+}
+
+// Observe indexes the peers in nm by every name they can be reached by.
+func (h *Handler) Observe(nm *netmap.NetworkMap) {
+	hosts := make(map[string]inventory.Host)
+	for _, host := range inventory.FromNetMap(nm) {
+		hosts[strings.ToLower(host.Name)] = host
+		hosts[strings.ToLower(host.ShortName())] = host
+		for _, ip := range host.IPs() {
+			hosts[ip.String()] = host
+		}
+	}
+
+	h.mu.Lock()
+	h.hosts = hosts
+	h.mu.Unlock()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(TokenHeader)
+	r.Header.Del(TokenHeader)
+
+	grant, ok := h.grants.lookup(token)
+	if !ok {
+		http.Error(w, "missing or expired proxy token", http.StatusUnauthorized)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/proxy/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	name, rest, _ := strings.Cut(rest, "/")
+	port, rest, hasPath := strings.Cut(rest, "/")
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		http.Error(w, "invalid port", http.StatusBadRequest)
+		return
+	}
+
+	// Send /proxy/<node>/<port> to the trailing slash so relative links
+	// in the page resolve under it.
+	base := "/proxy/" + name + "/" + port
+	if !hasPath {
+		http.Redirect(w, r, prefix(r)+base+"/", http.StatusFound)
+		return
+	}
+
+	h.mu.RLock()
+	host, ok := h.hosts[strings.ToLower(strings.TrimSuffix(name, "."))]
+	h.mu.RUnlock()
+	if !ok {
+		http.Error(w, "unknown node", http.StatusNotFound)
+		return
+	}
+
+	if !permitted(grant.Allow, host, port) {
+		util.GetLogger().Debug("Web proxy denied %s access to %s:%s", grant.User, host.Name, port)
+		http.Error(w, "node not allowed", http.StatusForbidden)
+		return
+	}
+
+	r.URL.Path = "/" + rest
+	r.URL.RawPath = ""
+	t := &target{host: host, port: port, base: prefix(r) + base}
+	h.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), targetKey{}, t)))
+}
+
+// permitted reports whether the rules allow any name the host has.
+func permitted(rules []proxy.Rule, host inventory.Host, port string) bool {
+	names := []string{host.Name, host.ShortName()}
+	for _, ip := range host.IPs() {
+		names = append(names, ip.String())
+	}
+
+	for _, n := range names {
+		if n != "" && proxy.Allowed(rules, net.JoinHostPort(n, port)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func rewrite(r *httputil.ProxyRequest) {
+	t := r.In.Context().Value(targetKey{}).(*target)
+	ip := t.host.IPv4
+	if !ip.IsValid() {
+		ip = t.host.IPv6
+	}
+
+	scheme := "http"
+	if t.port == "443" {
+		scheme = "https"
+	}
+
+	r.SetURL(&url.URL{Scheme: scheme, Host: net.JoinHostPort(ip.String(), t.port)})
+	r.Out.Host = t.host.Name
+	if t.port != "80" && t.port != "443" {
+		r.Out.Host = net.JoinHostPort(t.host.Name, t.port)
+	}
+
+	r.SetXForwarded()
+	r.Out.Header.Del(PrefixHeader)
+
+	// Only the node's own cookies are sent to it, under their own names.
+	r.Out.Header.Del("Cookie")
+	ours := t.cookiePrefix()
+	for _, c := range r.In.Cookies() {
+		if name, ok := strings.CutPrefix(c.Name, ours); ok {
+			r.Out.AddCookie(&http.Cookie{Name: name, Value: c.Value, Quoted: c.Quoted})
+		}
+	}
+}
+
+func modifyResponse(resp *http.Response) error {
+	rewriteCookies(resp)
+	return rewriteLocation(resp)
+}
+
+// rewriteCookies scopes cookies set by the node to its proxy path. Names
+// get the node's prefix, paths are moved under the proxy path and domains
+// are dropped so the cookie only goes back to Headplane's host. Cookies
+// that cannot be parsed are dropped.
+func rewriteCookies(resp *http.Response) {
+	lines := resp.Header.Values("Set-Cookie")
+	if len(lines) == 0 {
+		return
+	}
+
+	t := resp.Request.Context().Value(targetKey{}).(*target)
+	resp.Header.Del("Set-Cookie")
+	for _, line := range lines {
+		c, err := http.ParseSetCookie(line)
+		if err != nil {
+			util.GetLogger().Debug("Web proxy dropped a cookie from %s: %s", t.host.Name, err)
+			continue
+		}
+
+		c.Name = t.cookiePrefix() + c.Name
+		c.Domain = ""
+		if strings.HasPrefix(c.Path, "/") {
+			c.Path = t.base + c.Path
+		} else {
+			c.Path = t.base + "/"
+		}
+
+		if v := c.String(); v != "" {
+			resp.Header.Add("Set-Cookie", v)
+		}
+	}
+}
+
+// rewriteLocation points redirects back through the proxy. Absolute paths
+// and URLs for the node itself are rewritten; other URLs are left alone.
+func rewriteLocation(resp *http.Response) error {
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil
+	}
+
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil
+	}
+
+	t := resp.Request.Context().Value(targetKey{}).(*target)
+	if u.Host != "" {
+		if !sameNode(t, u) {
+			return nil
+		}
+	} else if !strings.HasPrefix(u.Path, "/") {
+		return nil
+	}
+
+	u.Scheme, u.Host, u.User = "", "", nil
+	u.Path = t.base + u.Path
+	if u.RawPath != "" {
+		u.RawPath = t.base + u.RawPath
+	}
+
+	resp.Header.Set("Location", u.String())
+	return nil
+}
+
+func sameNode(t *target, u *url.URL) bool {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	if port != t.port {
+		return false
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip == t.host.IPv4 || ip == t.host.IPv6
+	}
+
+	return host == strings.ToLower(t.host.Name) || host == strings.ToLower(t.host.ShortName())
+}
+
+func prefix(r *http.Request) string {
+	return strings.TrimSuffix(r.Header.Get(PrefixHeader), "/")
+}
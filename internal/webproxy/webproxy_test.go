@@ -0,0 +1,275 @@
+package webproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// hostDialer dials on the host network in place of the tailnet.
+type hostDialer struct{ net.Dialer }
+
+func (d *hostDialer) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.DialContext(ctx, network, addr)
+}
+
+// testHandler returns a handler that knows one node, "router", at
+// 127.0.0.1 and a token allowed to open it on any port.
+func testHandler(t *testing.T) (*Handler, string) {
+	t.Helper()
+	h := New(&hostDialer{})
+	h.Observe(&netmap.NetworkMap{Peers: []tailcfg.NodeView{(&tailcfg.Node{
+		ID:        1,
+		StableID:  "n1",
+		Name:      "router.example.ts.net.",
+		Addresses: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		Hostinfo:  (&tailcfg.Hostinfo{Hostname: "router"}).View(),
+	}).View()}})
+
+	token, _, err := h.Grant("alice", []string{"router:*"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h, token
+}
+
+func TestServeHTTP(t *testing.T) {
+	var gotPath string
+	var gotCookies []string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotCookies = r.Header.Values("Cookie")
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", Domain: "router.example.ts.net", HttpOnly: true})
+			http.SetCookie(w, &http.Cookie{Name: "lang", Value: "en"})
+			http.Redirect(w, r, "/admin", http.StatusFound)
+		}
+	}))
+	defer node.Close()
+
+	_, port, _ := net.SplitHostPort(node.Listener.Addr().String())
+	base := "/proxy/router/" + port
+
+	h, token := testHandler(t)
+	tests := []struct {
+		name         string
+		token        string
+		path         string
+		cookies      []string
+		wantStatus   int
+		wantPath     string
+		wantCookies  []string
+		wantSet      []string
+		wantLocation string
+	}{
+		{name: "no token", path: base + "/", wantStatus: http.StatusUnauthorized},
+		{name: "bad port", token: token, path: "/proxy/router/http/", wantStatus: http.StatusBadRequest},
+		{name: "unknown node", token: token, path: "/proxy/nas/80/", wantStatus: http.StatusNotFound},
+		{name: "trailing slash", token: token, path: base, wantStatus: http.StatusFound, wantLocation: "/hp" + base + "/"},
+		{
+			name:         "cookies are scoped to the node",
+			token:        token,
+			path:         base + "/login",
+			wantStatus:   http.StatusFound,
+			wantPath:     "/login",
+			wantLocation: "/hp" + base + "/admin",
+			wantSet: []string{
+				"hpn_n1_" + port + "_session=abc; Path=/hp" + base + "/; HttpOnly",
+				"hpn_n1_" + port + "_lang=en; Path=/hp" + base + "/",
+			},
+		},
+		{
+			name:        "only the node's cookies are forwarded",
+			token:       token,
+			path:        base + "/status",
+			cookies:     []string{"hp_sess=secret", "hpn_n1_" + port + "_session=abc", "hpn_n2_" + port + "_session=other", "hpn_n1_80_session=wrongport"},
+			wantStatus:  http.StatusOK,
+			wantPath:    "/status",
+			wantCookies: []string{"session=abc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotCookies = "", nil
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(PrefixHeader, "/hp/")
+			if tt.token != "" {
+				req.Header.Set(TokenHeader, tt.token)
+			}
+
+			for _, c := range tt.cookies {
+				req.Header.Add("Cookie", c)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if gotPath != tt.wantPath {
+				t.Errorf("node saw path %q, want %q", gotPath, tt.wantPath)
+			}
+
+			if !reflect.DeepEqual(gotCookies, tt.wantCookies) {
+				t.Errorf("node saw cookies %q, want %q", gotCookies, tt.wantCookies)
+			}
+
+			if got := rec.Header().Values("Set-Cookie"); !reflect.DeepEqual(got, tt.wantSet) {
+				t.Errorf("Set-Cookie = %q, want %q", got, tt.wantSet)
+			}
+
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	node := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ws, ws)
+	}))
+	defer node.Close()
+
+	_, port, _ := net.SplitHostPort(node.Listener.Addr().String())
+	h, token := testHandler(t)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		messages []string
+	}{
+		{name: "single message", messages: []string{"hello"}},
+		{name: "several messages", messages: []string{"one", "two", "three"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/proxy/router/" + port + "/echo"
+			cfg, err := websocket.NewConfig(u, srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Header.Set(TokenHeader, token)
+
+			ws, err := websocket.DialConfig(cfg)
+			if err != nil {
+				t.Fatalf("upgrade through the proxy: %v", err)
+			}
+			defer ws.Close()
+			ws.SetDeadline(time.Now().Add(5 * time.Second))
+
+			for _, msg := range tt.messages {
+				if err := websocket.Message.Send(ws, msg); err != nil {
+					t.Fatal(err)
+				}
+
+				var got string
+				if err := websocket.Message.Receive(ws, &got); err != nil {
+					t.Fatal(err)
+				}
+
+				if got != msg {
+					t.Errorf("echo = %q, want %q", got, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	h, _ := testHandler(t)
+	host := h.hosts["router"]
+
+	tests := []struct {
+		name     string
+		port     string
+		location string
+		want     string
+	}{
+		{name: "absolute path", port: "80", location: "/admin?x=1", want: "/base/admin?x=1"},
+		{name: "relative path", port: "80", location: "admin", want: "admin"},
+		{name: "same node by name", port: "80", location: "http://router.example.ts.net/admin", want: "/base/admin"},
+		{name: "same node by ip", port: "443", location: "https://127.0.0.1/admin", want: "/base/admin"},
+		{name: "other port", port: "8080", location: "http://router/admin", want: "http://router/admin"},
+		{name: "other host", port: "80", location: "http://example.com/", want: "http://example.com/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tgt := &target{host: host, port: tt.port, base: "/base"}
+			req := (&http.Request{URL: &url.URL{}}).WithContext(context.WithValue(context.Background(), targetKey{}, tgt))
+			resp := &http.Response{Header: http.Header{"Location": {tt.location}}, Request: req}
+			if err := rewriteLocation(resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := resp.Header.Get("Location"); got != tt.want {
+				t.Errorf("Location = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrant(t *testing.T) {
+	h := New(&hostDialer{})
+	first, _, err := h.Grant("alice", []string{"router:80"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, _, err := h.Grant("alice", []string{"router:80"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, _, err := h.Grant("bob", []string{"router:80"}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "replaced", token: first, want: false},
+		{name: "current", token: second, want: true},
+		{name: "expired", token: expired, want: false},
+		{name: "unknown", token: "nope", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := h.grants.lookup(tt.token); ok != tt.want {
+				t.Errorf("lookup = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+
+	h.Revoke("alice")
+	if _, ok := h.grants.lookup(second); ok {
+		t.Error("token still works after Revoke")
+	}
+
+	if _, _, err := h.Grant("alice", []string{"router"}, time.Minute); err == nil {
+		t.Error("Grant accepted an entry without a port")
+	}
+}
@@ -0,0 +1,112 @@
+package zone
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tale/headplane/internal/inventory"
+	"github.com/tale/headplane/internal/util"
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/netmap"
+)
+
+// Exporter writes zone files for the latest netmap into a directory, one
+// file per zone named <zone>.zone. A zone file is only rewritten, with a new
+// serial, when its records change.
+type Exporter struct {
+	dir string
+	ns  string
+	txt bool
+
+	mu     sync.Mutex
+	domain string
+	hosts  []inventory.Host
+	last   map[string][]string
+}
+
+// NewExporter returns an Exporter writing into dir. ns is the name server
+// used for the SOA and NS records.
+func NewExporter(dir, ns string, txt bool) *Exporter {
+	if !strings.HasSuffix(ns, ".") {
+		ns += "."
+	}
+
+	return &Exporter{dir: dir, ns: ns, txt: txt, last: make(map[string][]string)}
+}
+
+// Observe records the hosts in nm, including the agent's own node, for the
+// next export.
+func (e *Exporter) Observe(nm *netmap.NetworkMap) {
+	hosts := inventory.FromNetMap(nm)
+	if self, ok := inventory.Self(nm); ok {
+		hosts = append(hosts, self)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.domain = nm.MagicDNSSuffix()
+	e.hosts = hosts
+}
+
+// Run exports on every interval until ctx is done.
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.Export(); err != nil {
+			util.GetLogger().Error("Failed to export zone files: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Export writes every zone whose records changed since the last export.
+func (e *Exporter) Export() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.domain == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(e.dir, 0755); err != nil {
+		return err
+	}
+
+	// Zones that no longer have any records are written out empty so stale
+	// records are not served.
+	zones := Records(e.domain, e.hosts, e.txt)
+	for name := range e.last {
+		if _, ok := zones[name]; !ok {
+			zones[name] = []string{}
+		}
+	}
+
+	serial := time.Now().Unix()
+	for name, records := range zones {
+		slices.Sort(records)
+		if slices.Equal(records, e.last[name]) {
+			continue
+		}
+
+		path := filepath.Join(e.dir, strings.TrimSuffix(name, ".")+".zone")
+		if err := atomicfile.WriteFile(path, []byte(Render(name, e.ns, serial, records)), 0644); err != nil {
+			return err
+		}
+
+		e.last[name] = records
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package zone
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func testNode(id tailcfg.NodeID, name, addr string) tailcfg.NodeView {
+	return (&tailcfg.Node{
+		ID:        id,
+		StableID:  tailcfg.StableNodeID(name),
+		Name:      name + ".example.ts.net.",
+		Addresses: []netip.Prefix{netip.PrefixFrom(netip.MustParseAddr(addr), 32)},
+		Hostinfo:  (&tailcfg.Hostinfo{Hostname: name}).View(),
+	}).View()
+}
+
+func TestExporter(t *testing.T) {
+	tests := []struct {
+		name string
+		nm   *netmap.NetworkMap
+		want []string
+	}{
+		{
+			name: "includes the agent's own node",
+			nm: &netmap.NetworkMap{
+				Name:     "agent.example.ts.net.",
+				SelfNode: testNode(1, "agent", "100.64.0.1"),
+				Peers:    []tailcfg.NodeView{testNode(2, "web", "100.64.0.2")},
+			},
+			want: []string{"agent\tIN\tA\t100.64.0.1\n", "web\tIN\tA\t100.64.0.2\n"},
+		},
+		{
+			name: "no self node",
+			nm: &netmap.NetworkMap{
+				Name:  "agent.example.ts.net.",
+				Peers: []tailcfg.NodeView{testNode(2, "web", "100.64.0.2")},
+			},
+			want: []string{"web\tIN\tA\t100.64.0.2\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			e := NewExporter(dir, "ns.example.com", false)
+			e.Observe(tt.nm)
+			if err := e.Export(); err != nil {
+				t.Fatalf("Export: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "example.ts.net.zone"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("zone is missing %q:\n%s", want, data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package zone
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/tale/headplane/internal/inventory"
+)
+
+// ttl is the TTL of every record. Tailnet addresses rarely change, but
+// nodes come and go, so keep it short.
+const ttl = 300
+
+// Records builds the record lines for the forward zone of domain and the
+// reverse zones covering every host address, keyed by zone name. Reverse
+// zones are per /16 for IPv4 and per /48 for IPv6. TXT records with the
+// owner and tags are added to the forward zone if txt is set.
+func Records(domain string, hosts []inventory.Host, txt bool) map[string][]string {
+	domain = strings.TrimSuffix(domain, ".")
+	zones := map[string][]string{domain: {}}
+
+	for _, h := range hosts {
+		name := relative(h.Name, domain)
+		for _, ip := range h.IPs() {
+			typ := "A"
+			if ip.Is6() {
+				typ = "AAAA"
+			}
+
+			zones[domain] = append(zones[domain], fmt.Sprintf("%s\tIN\t%s\t%s", name, typ, ip))
+
+			rev, zoneName := reverse(ip)
+			zones[zoneName] = append(zones[zoneName], fmt.Sprintf("%s\tIN\tPTR\t%s.", relative(rev, zoneName), h.Name))
+		}
+
+		if txt {
+			fields := []string{quote("owner=" + h.User)}
+			if len(h.Tags) > 0 {
+				fields = append(fields, quote("tags="+strings.Join(h.Tags, ",")))
+			}
+
+			zones[domain] = append(zones[domain], fmt.Sprintf("%s\tIN\tTXT\t%s", name, strings.Join(fields, " ")))
+		}
+	}
+
+	return zones
+}
+
+// Render returns a complete zone file for records, with an SOA and NS
+// record pointing at ns.
+func Render(zoneName, ns string, serial int64, records []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "; Generated by hp_agent. Do not edit.\n")
+	fmt.Fprintf(&b, "$ORIGIN %s.\n", zoneName)
+	fmt.Fprintf(&b, "$TTL %d\n", ttl)
+	fmt.Fprintf(&b, "@\tIN\tSOA\t%s hostmaster.%s. %d 3600 600 86400 %d\n", ns, zoneName, serial, ttl)
+	fmt.Fprintf(&b, "@\tIN\tNS\t%s\n", ns)
+
+	records = slices.Clone(records)
+	slices.Sort(records)
+	for _, r := range records {
+		b.WriteString(r)
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// reverse returns the reverse lookup name for ip and the zone it goes in.
+func reverse(ip netip.Addr) (name, zoneName string) {
+	if ip.Is4() {
+		a := ip.As4()
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", a[3], a[2], a[1], a[0]),
+			fmt.Sprintf("%d.%d.in-addr.arpa", a[1], a[0])
+	}
+
+	a := ip.As16()
+	nibbles := make([]string, 0, 32)
+	for i := len(a) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x", a[i]&0xf), fmt.Sprintf("%x", a[i]>>4))
+	}
+
+	// The last 12 nibbles are the /48.
+	return strings.Join(nibbles, ".") + ".ip6.arpa", strings.Join(nibbles[20:], ".") + ".ip6.arpa"
+}
+
+// relative returns name relative to zoneName, or as an absolute name if it
+// is outside the zone.
+func relative(name, zoneName string) string {
+	if rel, ok := strings.CutSuffix(name, "."+zoneName); ok {
+		return rel
+	}
+
+	return name + "."
+}
+
+// quote returns s as a zone file character string.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
@@ -0,0 +1,94 @@
+package zone
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tale/headplane/internal/inventory"
+)
+
+func TestRecords(t *testing.T) {
+	web := inventory.Host{
+		Name: "web.example.ts.net",
+		User: "alice@example.com",
+		Tags: []string{"tag:server"},
+		IPv4: netip.MustParseAddr("100.64.0.1"),
+		IPv6: netip.MustParseAddr("fd7a:115c:a1e0::1"),
+	}
+
+	tests := []struct {
+		name  string
+		hosts []inventory.Host
+		txt   bool
+		want  map[string][]string
+	}{
+		{
+			name: "no hosts",
+			want: map[string][]string{"example.ts.net": {}},
+		},
+		{
+			name:  "forward and reverse",
+			hosts: []inventory.Host{web},
+			want: map[string][]string{
+				"example.ts.net": {
+					"web\tIN\tA\t100.64.0.1",
+					"web\tIN\tAAAA\tfd7a:115c:a1e0::1",
+				},
+				"64.100.in-addr.arpa": {"1.0\tIN\tPTR\tweb.example.ts.net."},
+				"0.e.1.a.c.5.1.1.a.7.d.f.ip6.arpa": {
+					"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0\tIN\tPTR\tweb.example.ts.net.",
+				},
+			},
+		},
+		{
+			name:  "txt records",
+			hosts: []inventory.Host{{Name: "db.example.ts.net", User: `bob "b"`}},
+			txt:   true,
+			want: map[string][]string{
+				"example.ts.net": {`db	IN	TXT	"owner=bob \"b\""`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Records("example.ts.net.", tt.hosts, tt.txt)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Records = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	got := Render("example.ts.net", "ns.example.com.", 42, []string{"b\tIN\tA\t100.64.0.2", "a\tIN\tA\t100.64.0.1"})
+	for _, want := range []string{
+		"$ORIGIN example.ts.net.\n",
+		"@\tIN\tSOA\tns.example.com. hostmaster.example.ts.net. 42 ",
+		"@\tIN\tNS\tns.example.com.\n",
+		"a\tIN\tA\t100.64.0.1\nb\tIN\tA\t100.64.0.2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Render is missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestRelative(t *testing.T) {
+	tests := []struct {
+		name, zone, want string
+	}{
+		{"web.example.ts.net", "example.ts.net", "web"},
+		{"web.other.ts.net", "example.ts.net", "web.other.ts.net."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relative(tt.name, tt.zone); got != tt.want {
+				t.Errorf("relative(%q, %q) = %q, want %q", tt.name, tt.zone, got, tt.want)
+			}
+		})
+	}
+}
@@ -82,4 +82,24 @@ describe("Configuration environment variable handling", () => {
     expect(config.oidc?.issuer).toBe("https://accounts.google.com");
     expect(config.oidc?.client_id).toBe("my-client-id");
   });
+
+  test("agent feature settings can be set via env vars", async () => {
+    process.env.HEADPLANE_INTEGRATION__AGENT__ENABLED = "true";
+    process.env.HEADPLANE_INTEGRATION__AGENT__POSTURE_FILE = "/etc/headplane/posture.json";
+    process.env.HEADPLANE_INTEGRATION__AGENT__ZONE_TXT = "true";
+    process.env.HEADPLANE_INTEGRATION__AGENT__PROXY_ALLOW = "100.64.0.0/10:*";
+
+    const config = await loadConfigEnv();
+    expect(config?.integration?.agent?.posture_file).toBe("/etc/headplane/posture.json");
+    expect(config?.integration?.agent?.zone_txt).toBe(true);
+    expect(config?.integration?.agent?.proxy_allow).toBe("100.64.0.0/10:*");
+  });
+
+  test("HEADPLANE_AGENT_* variables are not agent settings", async () => {
+    process.env.HEADPLANE_INTEGRATION__AGENT__ENABLED = "true";
+    process.env.HEADPLANE_AGENT_POSTURE_FILE = "/etc/headplane/posture.json";
+
+    const config = await loadConfigEnv();
+    expect(config?.integration?.agent?.posture_file).toBeUndefined();
+  });
 });
@@ -0,0 +1,26 @@
+import { describe, expect, test } from "vitest";
+
+import { agentTraceparent } from "~/utils/trace-context";
+
+describe("agentTraceparent", () => {
+  test("returns nothing without a parent", () => {
+    expect(agentTraceparent()).toBeUndefined();
+    expect(agentTraceparent(null)).toBeUndefined();
+  });
+
+  test.each([
+    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+  ])("passes the parent %s through unchanged", (parent) => {
+    expect(agentTraceparent(parent)).toBe(parent);
+  });
+
+  test.each([
+    "garbage",
+    "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+    "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+    "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+  ])("ignores an invalid parent %s", (parent) => {
+    expect(agentTraceparent(parent)).toBeUndefined();
+  });
+});